@@ -158,10 +158,12 @@ func TestJobs_Canonicalize(t *testing.T) {
 							SizeMB:  helper.IntToPtr(300),
 						},
 						RestartPolicy: &RestartPolicy{
-							Delay:    helper.TimeToPtr(15 * time.Second),
-							Attempts: helper.IntToPtr(2),
-							Interval: helper.TimeToPtr(30 * time.Minute),
-							Mode:     helper.StringToPtr("fail"),
+							Delay:        helper.TimeToPtr(15 * time.Second),
+							InitialDelay: helper.TimeToPtr(0),
+							Jitter:       helper.TimeToPtr(0),
+							Attempts:     helper.IntToPtr(2),
+							Interval:     helper.TimeToPtr(30 * time.Minute),
+							Mode:         helper.StringToPtr("fail"),
 						},
 						ReschedulePolicy: &ReschedulePolicy{
 							Attempts:      helper.IntToPtr(0),
@@ -229,10 +231,12 @@ func TestJobs_Canonicalize(t *testing.T) {
 							SizeMB:  helper.IntToPtr(300),
 						},
 						RestartPolicy: &RestartPolicy{
-							Delay:    helper.TimeToPtr(15 * time.Second),
-							Attempts: helper.IntToPtr(2),
-							Interval: helper.TimeToPtr(30 * time.Minute),
-							Mode:     helper.StringToPtr("fail"),
+							Delay:        helper.TimeToPtr(15 * time.Second),
+							InitialDelay: helper.TimeToPtr(0),
+							Jitter:       helper.TimeToPtr(0),
+							Attempts:     helper.IntToPtr(2),
+							Interval:     helper.TimeToPtr(30 * time.Minute),
+							Mode:         helper.StringToPtr("fail"),
 						},
 						ReschedulePolicy: &ReschedulePolicy{
 							Attempts:      helper.IntToPtr(0),
@@ -369,10 +373,12 @@ func TestJobs_Canonicalize(t *testing.T) {
 						Name:  helper.StringToPtr("cache"),
 						Count: helper.IntToPtr(1),
 						RestartPolicy: &RestartPolicy{
-							Interval: helper.TimeToPtr(5 * time.Minute),
-							Attempts: helper.IntToPtr(10),
-							Delay:    helper.TimeToPtr(25 * time.Second),
-							Mode:     helper.StringToPtr("delay"),
+							Interval:     helper.TimeToPtr(5 * time.Minute),
+							Attempts:     helper.IntToPtr(10),
+							Delay:        helper.TimeToPtr(25 * time.Second),
+							InitialDelay: helper.TimeToPtr(0),
+							Jitter:       helper.TimeToPtr(0),
+							Mode:         helper.StringToPtr("delay"),
 						},
 						ReschedulePolicy: &ReschedulePolicy{
 							Attempts:      helper.IntToPtr(0),
@@ -593,10 +599,12 @@ func TestJobs_Canonicalize(t *testing.T) {
 							SizeMB:  helper.IntToPtr(300),
 						},
 						RestartPolicy: &RestartPolicy{
-							Delay:    helper.TimeToPtr(15 * time.Second),
-							Attempts: helper.IntToPtr(2),
-							Interval: helper.TimeToPtr(30 * time.Minute),
-							Mode:     helper.StringToPtr("fail"),
+							Delay:        helper.TimeToPtr(15 * time.Second),
+							InitialDelay: helper.TimeToPtr(0),
+							Jitter:       helper.TimeToPtr(0),
+							Attempts:     helper.IntToPtr(2),
+							Interval:     helper.TimeToPtr(30 * time.Minute),
+							Mode:         helper.StringToPtr("fail"),
 						},
 						ReschedulePolicy: &ReschedulePolicy{
 							Attempts:      helper.IntToPtr(0),
@@ -635,10 +643,12 @@ func TestJobs_Canonicalize(t *testing.T) {
 							SizeMB:  helper.IntToPtr(300),
 						},
 						RestartPolicy: &RestartPolicy{
-							Delay:    helper.TimeToPtr(15 * time.Second),
-							Attempts: helper.IntToPtr(2),
-							Interval: helper.TimeToPtr(30 * time.Minute),
-							Mode:     helper.StringToPtr("fail"),
+							Delay:        helper.TimeToPtr(15 * time.Second),
+							InitialDelay: helper.TimeToPtr(0),
+							Jitter:       helper.TimeToPtr(0),
+							Attempts:     helper.IntToPtr(2),
+							Interval:     helper.TimeToPtr(30 * time.Minute),
+							Mode:         helper.StringToPtr("fail"),
 						},
 						ReschedulePolicy: &ReschedulePolicy{
 							Attempts:      helper.IntToPtr(0),
@@ -58,10 +58,12 @@ type AllocResourceUsage struct {
 // RestartPolicy defines how the Nomad client restarts
 // tasks in a taskgroup when they fail
 type RestartPolicy struct {
-	Interval *time.Duration
-	Attempts *int
-	Delay    *time.Duration
-	Mode     *string
+	Interval     *time.Duration
+	Attempts     *int
+	Delay        *time.Duration
+	InitialDelay *time.Duration `mapstructure:"initial_delay"`
+	Jitter       *time.Duration
+	Mode         *string
 }
 
 func (r *RestartPolicy) Merge(rp *RestartPolicy) {
@@ -74,6 +76,12 @@ func (r *RestartPolicy) Merge(rp *RestartPolicy) {
 	if rp.Delay != nil {
 		r.Delay = rp.Delay
 	}
+	if rp.InitialDelay != nil {
+		r.InitialDelay = rp.InitialDelay
+	}
+	if rp.Jitter != nil {
+		r.Jitter = rp.Jitter
+	}
 	if rp.Mode != nil {
 		r.Mode = rp.Mode
 	}
@@ -502,17 +510,21 @@ func (g *TaskGroup) Canonicalize(job *Job) {
 	switch *job.Type {
 	case "service", "system":
 		defaultRestartPolicy = &RestartPolicy{
-			Delay:    helper.TimeToPtr(structs.DefaultServiceJobRestartPolicy.Delay),
-			Attempts: helper.IntToPtr(structs.DefaultServiceJobRestartPolicy.Attempts),
-			Interval: helper.TimeToPtr(structs.DefaultServiceJobRestartPolicy.Interval),
-			Mode:     helper.StringToPtr(structs.DefaultServiceJobRestartPolicy.Mode),
+			Delay:        helper.TimeToPtr(structs.DefaultServiceJobRestartPolicy.Delay),
+			InitialDelay: helper.TimeToPtr(structs.DefaultServiceJobRestartPolicy.InitialDelay),
+			Jitter:       helper.TimeToPtr(structs.DefaultServiceJobRestartPolicy.Jitter),
+			Attempts:     helper.IntToPtr(structs.DefaultServiceJobRestartPolicy.Attempts),
+			Interval:     helper.TimeToPtr(structs.DefaultServiceJobRestartPolicy.Interval),
+			Mode:         helper.StringToPtr(structs.DefaultServiceJobRestartPolicy.Mode),
 		}
 	default:
 		defaultRestartPolicy = &RestartPolicy{
-			Delay:    helper.TimeToPtr(structs.DefaultBatchJobRestartPolicy.Delay),
-			Attempts: helper.IntToPtr(structs.DefaultBatchJobRestartPolicy.Attempts),
-			Interval: helper.TimeToPtr(structs.DefaultBatchJobRestartPolicy.Interval),
-			Mode:     helper.StringToPtr(structs.DefaultBatchJobRestartPolicy.Mode),
+			Delay:        helper.TimeToPtr(structs.DefaultBatchJobRestartPolicy.Delay),
+			InitialDelay: helper.TimeToPtr(structs.DefaultBatchJobRestartPolicy.InitialDelay),
+			Jitter:       helper.TimeToPtr(structs.DefaultBatchJobRestartPolicy.Jitter),
+			Attempts:     helper.IntToPtr(structs.DefaultBatchJobRestartPolicy.Attempts),
+			Interval:     helper.TimeToPtr(structs.DefaultBatchJobRestartPolicy.Interval),
+			Mode:         helper.StringToPtr(structs.DefaultBatchJobRestartPolicy.Mode),
 		}
 	}
 
@@ -818,6 +830,8 @@ type TaskEvent struct {
 	Time           int64
 	DisplayMessage string
 	Details        map[string]string
+	Severity       string
+	Sticky         bool
 	// DEPRECATION NOTICE: The following fields are all deprecated. see TaskEvent struct in structs.go for details.
 	FailsTask        bool
 	RestartReason    string
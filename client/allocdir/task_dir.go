@@ -132,6 +132,30 @@ func (t *TaskDir) Build(chrootCreated bool, chroot map[string]string, fsi cstruc
 	return nil
 }
 
+// CleanLocal removes the contents of the task's local and secrets
+// directories. The directories themselves are kept as the secrets directory
+// may be a mount point. The shared alloc directory is not modified.
+func (t *TaskDir) CleanLocal() error {
+	for _, dir := range []string{t.LocalDir, t.SecretsDir} {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read directory %q: %v", dir, err)
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to remove %q: %v", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // buildChroot takes a mapping of absolute directory or file paths on the host
 // to their intended, relative location within the task directory. This
 // attempts hardlink and then defaults to copying. If the path exists on the
@@ -137,3 +137,54 @@ func TestTaskDir_NonRoot(t *testing.T) {
 	}
 
 }
+
+// Test that cleaning a task dir removes local and secrets data but preserves
+// the shared alloc dir.
+func TestTaskDir_CleanLocal(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "AllocDir")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	d := NewAllocDir(testlog.Logger(t), tmp)
+	defer d.Destroy()
+	td := d.NewTaskDir(t1.Name)
+	if err := d.Build(); err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+
+	if err := td.Build(false, nil, cstructs.FSIsolationNone); err != nil {
+		t.Fatalf("TaskDir.Build failed: %v", err)
+	}
+
+	localFile := filepath.Join(td.LocalDir, "foo", "bar")
+	secretFile := filepath.Join(td.SecretsDir, "token")
+	sharedFile := filepath.Join(td.SharedAllocDir, "shared")
+	if err := os.MkdirAll(filepath.Dir(localFile), 0777); err != nil {
+		t.Fatalf("Couldn't create local dir: %v", err)
+	}
+	for _, f := range []string{localFile, secretFile, sharedFile} {
+		if err := ioutil.WriteFile(f, []byte("foo"), 0666); err != nil {
+			t.Fatalf("Couldn't write file %q: %v", f, err)
+		}
+	}
+
+	if err := td.CleanLocal(); err != nil {
+		t.Fatalf("CleanLocal failed: %v", err)
+	}
+
+	for _, dir := range []string{td.LocalDir, td.SecretsDir} {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Couldn't read dir %q: %v", dir, err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("Expected %q to be empty; found %d entries", dir, len(entries))
+		}
+	}
+
+	if _, err := os.Stat(sharedFile); err != nil {
+		t.Fatalf("Expected shared alloc data to be preserved: %v", err)
+	}
+}
@@ -31,6 +31,23 @@ var (
 	allocRunnerStateAllocDirKey  = []byte("alloc-dir")
 )
 
+const (
+	// taskEventCapacity is the maximum number of events kept per task
+	taskEventCapacity = 10
+
+	// maxTaskEventMessageLen is the maximum length of an event's display and
+	// driver messages. Longer messages are truncated.
+	maxTaskEventMessageLen = 2048
+
+	// taskEventBytesBudget is the maximum total size of a task's events. The
+	// oldest events are trimmed when it is exceeded, regardless of count.
+	taskEventBytesBudget = 16 * 1024
+
+	// maxStickyTaskEvents is the maximum number of sticky events kept per
+	// task. Once exceeded the oldest sticky events are evicted like any other.
+	maxStickyTaskEvents = 3
+)
+
 // AllocStateUpdater is used to update the status of an allocation
 type AllocStateUpdater func(alloc *structs.Allocation)
 
@@ -67,6 +84,10 @@ type AllocRunner struct {
 	restored   map[string]struct{}
 	taskLock   sync.RWMutex
 
+	// restoreFailedTask is the name of a task whose task runner couldn't be
+	// created when restoring. It is only accessed by RestoreState and Run.
+	restoreFailedTask string
+
 	taskStatusLock sync.RWMutex
 
 	updateCh chan *structs.Allocation
@@ -127,8 +148,11 @@ type allocRunnerImmutableState struct {
 type allocRunnerMutableState struct {
 	AllocClientStatus      string
 	AllocClientDescription string
-	TaskStates             map[string]*structs.TaskState
-	DeploymentStatus       *structs.AllocDeploymentStatus
+
+	// TaskStates includes each task's StartedAt and FinishedAt so that they
+	// survive an agent restart.
+	TaskStates       map[string]*structs.TaskState
+	DeploymentStatus *structs.AllocDeploymentStatus
 }
 
 // NewAllocRunner is used to create a new allocation context
@@ -187,6 +211,30 @@ func (r *AllocRunner) setBaseLabels() {
 	}
 }
 
+// emitTaskAttributes emits the task's identity to the configured attribute
+// sink, if any, using OpenTelemetry style keys.
+func (r *AllocRunner) emitTaskAttributes(taskName, state string) {
+	sink := r.config.AttributeSink
+	if sink == nil {
+		return
+	}
+
+	attrs := make([]config.Attribute, 0, 5)
+	if r.alloc.Job != nil {
+		attrs = append(attrs, config.Attribute{Key: "nomad.job.name", Value: r.alloc.Job.Name})
+	}
+	attrs = append(attrs,
+		config.Attribute{Key: "nomad.task_group.name", Value: r.alloc.TaskGroup},
+		config.Attribute{Key: "nomad.alloc.id", Value: r.alloc.ID},
+		config.Attribute{Key: "nomad.task.name", Value: taskName},
+	)
+	if r.config.Node != nil {
+		attrs = append(attrs, config.Attribute{Key: "nomad.node.id", Value: r.config.Node.ID})
+	}
+
+	sink.EmitTaskState(state, attrs)
+}
+
 // pre060StateFilePath returns the path to our state file that would have been
 // written pre v0.6.0
 // COMPAT: Remove in 0.7.0
@@ -285,7 +333,17 @@ func (r *AllocRunner) RestoreState() error {
 			continue
 		}
 
-		tr := taskrunner.NewTaskRunner(r.logger, r.config, r.stateDB, r.setTaskState, td, r.Alloc(), task, r.vaultClient, r.consulClient)
+		tr, err := taskrunner.NewTaskRunner(r.logger, r.config, r.stateDB, r.setTaskState, td, r.Alloc(), task, r.vaultClient, r.consulClient)
+		if err != nil {
+			// Without its driver the task can't be restored or killed so
+			// fail it. Run then stops the other tasks of the alloc.
+			r.logger.Printf("[ERR] client: failed to create task runner for alloc %s task %q: %v", r.allocID, name, err)
+			r.setTaskState(name, structs.TaskStateDead,
+				structs.NewTaskEvent(structs.TaskSetupFailure).SetSetupError(err).SetFailsTask(),
+				false)
+			r.restoreFailedTask = name
+			continue
+		}
 		r.tasks[name] = tr
 
 		if restartReason, err := tr.RestoreState(); err != nil {
@@ -648,11 +706,11 @@ func (r *AllocRunner) setTaskState(taskName, state string, event *structs.TaskEv
 			taskState.Failed = true
 		}
 		if event.Type == structs.TaskRestarting {
-			if !r.config.DisableTaggedMetrics {
+			if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounterWithLabels([]string{"client", "allocs", "restart"},
 					1, r.baseLabels)
 			}
-			if r.config.BackwardsCompatibleMetrics {
+			if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, taskName, "restart"}, 1)
 			}
 			taskState.Restarts++
@@ -674,17 +732,30 @@ func (r *AllocRunner) setTaskState(taskName, state string, event *structs.TaskEv
 	}
 
 	switch state {
+	case structs.TaskStatePending:
+		// Count tasks entering pending, either initially or when restarting
+		if taskState.State != structs.TaskStatePending {
+			if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
+				metrics.IncrCounterWithLabels([]string{"client", "allocs", "pending"},
+					1, r.baseLabels)
+			}
+			if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
+				metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, taskName, "pending"}, 1)
+			}
+			r.emitTaskAttributes(taskName, state)
+		}
 	case structs.TaskStateRunning:
 		// Capture the start time if it is just starting
 		if taskState.State != structs.TaskStateRunning {
 			taskState.StartedAt = time.Now().UTC()
-			if !r.config.DisableTaggedMetrics {
+			if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounterWithLabels([]string{"client", "allocs", "running"},
 					1, r.baseLabels)
 			}
-			if r.config.BackwardsCompatibleMetrics {
+			if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, taskName, "running"}, 1)
 			}
+			r.emitTaskAttributes(taskName, state)
 		}
 	case structs.TaskStateDead:
 		// Capture the finished time if not already set
@@ -708,22 +779,23 @@ func (r *AllocRunner) setTaskState(taskName, state string, event *structs.TaskEv
 
 		// Emitting metrics to indicate task complete and failures
 		if taskState.Failed {
-			if !r.config.DisableTaggedMetrics {
+			if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounterWithLabels([]string{"client", "allocs", "failed"},
 					1, r.baseLabels)
 			}
-			if r.config.BackwardsCompatibleMetrics {
+			if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, taskName, "failed"}, 1)
 			}
 		} else {
-			if !r.config.DisableTaggedMetrics {
+			if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounterWithLabels([]string{"client", "allocs", "complete"},
 					1, r.baseLabels)
 			}
-			if r.config.BackwardsCompatibleMetrics {
+			if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
 				metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, taskName, "complete"}, 1)
 			}
 		}
+		r.emitTaskAttributes(taskName, state)
 
 		// If the task failed, we should kill all the other tasks in the task group.
 		if taskState.Failed {
@@ -753,21 +825,130 @@ func (r *AllocRunner) setTaskState(taskName, state string, event *structs.TaskEv
 	}
 }
 
+// RestoreTaskEvents loads previously recorded events into a task's event
+// history, such as when migrating a task from another runner. Events are
+// appended oldest first within the usual capacity, and unlike setTaskState
+// they don't emit metrics or update the task's restart count or state.
+func (r *AllocRunner) RestoreTaskEvents(taskName string, events []*structs.TaskEvent) {
+	r.taskStatusLock.Lock()
+	defer r.taskStatusLock.Unlock()
+	taskState, ok := r.taskStates[taskName]
+	if !ok {
+		taskState = &structs.TaskState{}
+		r.taskStates[taskName] = taskState
+	}
+
+	for _, event := range events {
+		if event != nil {
+			r.appendTaskEvent(taskState, event)
+		}
+	}
+
+	select {
+	case r.dirtyCh <- struct{}{}:
+	default:
+	}
+}
+
 // appendTaskEvent updates the task status by appending the new event.
 func (r *AllocRunner) appendTaskEvent(state *structs.TaskState, event *structs.TaskEvent) {
-	capacity := 10
 	if state.Events == nil {
-		state.Events = make([]*structs.TaskEvent, 0, capacity)
+		state.Events = make([]*structs.TaskEvent, 0, taskEventCapacity)
 	}
 
-	// If we hit capacity, then shift it.
-	if len(state.Events) == capacity {
-		old := state.Events
-		state.Events = make([]*structs.TaskEvent, 0, capacity)
-		state.Events = append(state.Events, old[1:]...)
+	// Events restored from older state have no severity
+	if event.Severity == "" {
+		event = event.Copy()
+		event.Severity = structs.TaskEventSeverity(event.Type)
+	}
+
+	// Truncate overly long messages
+	if len(event.DisplayMessage) > maxTaskEventMessageLen ||
+		len(event.DriverMessage) > maxTaskEventMessageLen {
+		event = event.Copy()
+		event.DisplayMessage = truncateEventMessage(event.DisplayMessage)
+		event.DriverMessage = truncateEventMessage(event.DriverMessage)
+	}
+
+	// Only keep the newest liveness event so they don't evict other events
+	if isTaskLivenessEvent(event) {
+		for i, e := range state.Events {
+			if isTaskLivenessEvent(e) {
+				old := state.Events
+				state.Events = make([]*structs.TaskEvent, 0, taskEventCapacity)
+				state.Events = append(state.Events, old[:i]...)
+				state.Events = append(state.Events, old[i+1:]...)
+				break
+			}
+		}
 	}
 
 	state.Events = append(state.Events, event)
+
+	// Evict events while over capacity or the byte budget, always keeping the
+	// newest event.
+	size := 0
+	for _, e := range state.Events {
+		size += taskEventSize(e)
+	}
+	for len(state.Events) > 1 && (len(state.Events) > taskEventCapacity || size > taskEventBytesBudget) {
+		i := evictableTaskEvent(state.Events)
+		size -= taskEventSize(state.Events[i])
+		old := state.Events
+		state.Events = make([]*structs.TaskEvent, 0, taskEventCapacity)
+		state.Events = append(state.Events, old[:i]...)
+		state.Events = append(state.Events, old[i+1:]...)
+	}
+}
+
+// isTaskLivenessEvent returns whether the event is one of the periodic events
+// emitted while a task is running.
+func isTaskLivenessEvent(e *structs.TaskEvent) bool {
+	return e.Type == structs.TaskDriverMessage && e.DriverMessage == taskrunner.TaskLivenessMessage
+}
+
+// evictableTaskEvent returns the index of the event to evict: the oldest event
+// that isn't sticky, or the oldest sticky event if there are more than
+// maxStickyTaskEvents. The newest event is never returned.
+func evictableTaskEvent(events []*structs.TaskEvent) int {
+	sticky := 0
+	for _, e := range events {
+		if e.Sticky {
+			sticky++
+		}
+	}
+
+	last := len(events) - 1
+	for i, e := range events[:last] {
+		if !e.Sticky || sticky > maxStickyTaskEvents {
+			return i
+		}
+	}
+	return 0
+}
+
+// truncateEventMessage truncates a task event message to the maximum length.
+func truncateEventMessage(msg string) string {
+	const suffix = "...(truncated)"
+	if len(msg) <= maxTaskEventMessageLen {
+		return msg
+	}
+	return msg[:maxTaskEventMessageLen-len(suffix)] + suffix
+}
+
+// taskEventSize returns the approximate size of a task event in bytes, based
+// on its variable length fields.
+func taskEventSize(e *structs.TaskEvent) int {
+	size := len(e.Type) + len(e.Message) + len(e.DisplayMessage) +
+		len(e.RestartReason) + len(e.SetupError) + len(e.DriverError) +
+		len(e.KillError) + len(e.KillReason) + len(e.DownloadError) +
+		len(e.ValidationError) + len(e.FailedSibling) + len(e.VaultError) +
+		len(e.TaskSignalReason) + len(e.TaskSignal) + len(e.DriverMessage) +
+		len(e.GenericSource) + len(e.Severity)
+	for k, v := range e.Details {
+		size += len(k) + len(v)
+	}
+	return size
 }
 
 // Run is a long running goroutine used to manage an allocation
@@ -839,11 +1020,11 @@ func (r *AllocRunner) Run() {
 	}
 
 	// Increment alloc runner start counter. Incr'd even when restoring existing tasks so 1 start != 1 task execution
-	if !r.config.DisableTaggedMetrics {
+	if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
 		metrics.IncrCounterWithLabels([]string{"client", "allocs", "start"},
 			1, r.baseLabels)
 	}
-	if r.config.BackwardsCompatibleMetrics {
+	if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
 		metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, "start"}, 1)
 	}
 
@@ -854,7 +1035,11 @@ func (r *AllocRunner) Run() {
 	// Start the task runners
 	r.logger.Printf("[DEBUG] client: starting task runners for alloc '%s'", r.allocID)
 	r.taskLock.Lock()
+	failedTask := r.restoreFailedTask
 	for _, task := range tg.Tasks {
+		if failedTask != "" {
+			break
+		}
 		if _, ok := r.restored[task.Name]; ok {
 			continue
 		}
@@ -863,7 +1048,17 @@ func (r *AllocRunner) Run() {
 		taskdir := r.allocDir.NewTaskDir(task.Name)
 		r.allocDirLock.Unlock()
 
-		tr := taskrunner.NewTaskRunner(r.logger, r.config, r.stateDB, r.setTaskState, taskdir, r.Alloc(), task.Copy(), r.vaultClient, r.consulClient)
+		tr, err := taskrunner.NewTaskRunner(r.logger, r.config, r.stateDB, r.setTaskState, taskdir, r.Alloc(), task.Copy(), r.vaultClient, r.consulClient)
+		if err != nil {
+			// The task group was found above so the driver couldn't be
+			// created. Fail the task and don't start the remaining ones.
+			r.logger.Printf("[ERR] client: alloc %q failed to create task runner for task %q: %v", r.allocID, task.Name, err)
+			r.setTaskState(task.Name, structs.TaskStateDead,
+				structs.NewTaskEvent(structs.TaskSetupFailure).SetSetupError(err).SetFailsTask(),
+				false)
+			failedTask = task.Name
+			break
+		}
 		r.tasks[task.Name] = tr
 		tr.MarkReceived()
 
@@ -871,6 +1066,16 @@ func (r *AllocRunner) Run() {
 	}
 	r.taskLock.Unlock()
 
+	// If a task runner couldn't be created, now or when restoring, destroy
+	// the ones already started and wait to be destroyed.
+	if failedTask != "" {
+		r.destroyTaskRunners(structs.NewTaskEvent(structs.TaskSiblingFailed).SetFailedSibling(failedTask))
+		r.handleDestroy()
+		watcherCancel()
+		r.logger.Printf("[DEBUG] client: terminating runner for alloc '%s'", r.allocID)
+		return
+	}
+
 	// taskDestroyEvent contains an event that caused the destruction of a task
 	// in the allocation.
 	var taskDestroyEvent *structs.TaskEvent
@@ -985,11 +1190,11 @@ func (r *AllocRunner) handleDestroy() {
 	alloc := r.Alloc()
 
 	// Increment the destroy count for this alloc runner since this allocation is being removed from this client.
-	if !r.config.DisableTaggedMetrics {
+	if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
 		metrics.IncrCounterWithLabels([]string{"client", "allocs", "destroy"},
 			1, r.baseLabels)
 	}
-	if r.config.BackwardsCompatibleMetrics {
+	if r.config.BackwardsCompatibleMetrics && !r.config.AllocMetrics.Paused() {
 		metrics.IncrCounter([]string{"client", "allocs", r.alloc.Job.Name, r.alloc.TaskGroup, "destroy"}, 1)
 	}
 
@@ -1141,6 +1346,19 @@ func (r *AllocRunner) Destroy() {
 	r.allocBroadcast.Close()
 }
 
+// Shutdown is used to make the alloc's task runners exit without killing their
+// tasks, such as when the agent is shutting down, so they may be recovered when
+// the agent is restarted. It blocks until the task runners have exited.
+func (r *AllocRunner) Shutdown() {
+	runners := r.getTaskRunners()
+	for _, tr := range runners {
+		tr.Shutdown()
+	}
+	for _, tr := range runners {
+		<-tr.WaitCh()
+	}
+}
+
 // IsDestroyed returns true if the AllocRunner is not running and has been
 // destroyed (GC'd).
 func (r *AllocRunner) IsDestroyed() bool {
@@ -6,9 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/boltdb/bolt"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/nomad/command/agent/consul"
@@ -20,6 +22,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/hashicorp/nomad/client/allocrunner/taskrunner"
+	"github.com/hashicorp/nomad/client/config"
 	consulApi "github.com/hashicorp/nomad/client/consul"
 	"github.com/hashicorp/nomad/client/state"
 	"github.com/stretchr/testify/require"
@@ -96,7 +99,284 @@ func TestAllocRunner_FinishedAtSet_TaskEvents(t *testing.T) {
 
 }
 
+// TestAllocRunner_AppendTaskEvent_Truncate asserts overly long event messages
+// are truncated.
+func TestAllocRunner_AppendTaskEvent_Truncate(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ar := &AllocRunner{}
+	state := &structs.TaskState{}
+
+	long := strings.Repeat("a", 2*maxTaskEventMessageLen)
+	event := structs.NewTaskEvent(structs.TaskDriverMessage).SetDriverMessage(long)
+	event.DisplayMessage = long
+	ar.appendTaskEvent(state, event)
+
+	require.Len(state.Events, 1)
+	e := state.Events[0]
+	require.Len(e.DisplayMessage, maxTaskEventMessageLen)
+	require.Len(e.DriverMessage, maxTaskEventMessageLen)
+	require.True(strings.HasSuffix(e.DriverMessage, "(truncated)"))
+
+	// The caller's event is not modified
+	require.Equal(long, event.DriverMessage)
+}
+
+// TestAllocRunner_AppendTaskEvent_Severity asserts the severity of events is
+// preserved and defaulted for events without one.
+func TestAllocRunner_AppendTaskEvent_Severity(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ar := &AllocRunner{}
+	state := &structs.TaskState{}
+
+	warn := structs.NewTaskEvent(structs.TaskDriverMessage).SetSeverity(structs.TaskEventSeverityWarn)
+	ar.appendTaskEvent(state, warn)
+
+	// Events from older state have no severity
+	old := structs.NewTaskEvent(structs.TaskSetupFailure)
+	old.Severity = ""
+	ar.appendTaskEvent(state, old)
+
+	require.Len(state.Events, 2)
+	require.Equal(structs.TaskEventSeverityWarn, state.Events[0].Severity)
+	require.Equal(structs.TaskEventSeverityError, state.Events[1].Severity)
+
+	// The caller's event is not modified
+	require.Empty(old.Severity)
+}
+
+// TestAllocRunner_AppendTaskEvent_Sticky asserts sticky events survive being
+// flooded by other events, up to the maximum number of sticky events.
+func TestAllocRunner_AppendTaskEvent_Sticky(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ar := &AllocRunner{}
+	state := &structs.TaskState{}
+
+	ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskReceived))
+	ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskStarted).SetSticky())
+	for i := 0; i < 2*taskEventCapacity; i++ {
+		ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskDriverMessage).SetDriverMessage(fmt.Sprintf("%d", i)))
+	}
+
+	require.Len(state.Events, taskEventCapacity)
+	require.Equal(structs.TaskStarted, state.Events[0].Type)
+	for i, e := range state.Events[1:] {
+		require.Equal(structs.TaskDriverMessage, e.Type)
+		require.Equal(fmt.Sprintf("%d", taskEventCapacity+1+i), e.DriverMessage)
+	}
+
+	// Only the newest sticky events are kept once over the maximum
+	state = &structs.TaskState{}
+	for i := 0; i < maxStickyTaskEvents+2; i++ {
+		event := structs.NewTaskEvent(structs.TaskStarted).SetSticky()
+		event.Details = map[string]string{"i": fmt.Sprintf("%d", i)}
+		ar.appendTaskEvent(state, event)
+	}
+	for i := 0; i < 2*taskEventCapacity; i++ {
+		ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskDriverMessage))
+	}
+
+	require.Len(state.Events, taskEventCapacity)
+	for i := 0; i < maxStickyTaskEvents; i++ {
+		require.True(state.Events[i].Sticky)
+		require.Equal(fmt.Sprintf("%d", i+2), state.Events[i].Details["i"])
+	}
+	require.False(state.Events[maxStickyTaskEvents].Sticky)
+}
+
+// TestAllocRunner_AppendTaskEvent_Liveness asserts only the newest liveness
+// event is kept so they don't evict other events.
+func TestAllocRunner_AppendTaskEvent_Liveness(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ar := &AllocRunner{}
+	state := &structs.TaskState{}
+
+	ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskReceived))
+	ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskStarted))
+	for i := 0; i < 2*taskEventCapacity; i++ {
+		liveness := structs.NewTaskEvent(structs.TaskDriverMessage).SetDriverMessage(taskrunner.TaskLivenessMessage)
+		liveness.Details = map[string]string{"i": fmt.Sprintf("%d", i)}
+		ar.appendTaskEvent(state, liveness)
+	}
+
+	require.Len(state.Events, 3)
+	require.Equal(structs.TaskReceived, state.Events[0].Type)
+	require.Equal(structs.TaskStarted, state.Events[1].Type)
+	require.Equal(taskrunner.TaskLivenessMessage, state.Events[2].DriverMessage)
+	require.Equal(fmt.Sprintf("%d", 2*taskEventCapacity-1), state.Events[2].Details["i"])
+}
+
+// TestAllocRunner_AppendTaskEvent_BytesBudget asserts the oldest events are
+// trimmed when the total size of a task's events exceeds the budget.
+func TestAllocRunner_AppendTaskEvent_BytesBudget(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ar := &AllocRunner{}
+	state := &structs.TaskState{}
+
+	// Each event is large enough that only a few fit in the budget
+	large := strings.Repeat("a", taskEventBytesBudget/3)
+	for i := 0; i < 5; i++ {
+		event := structs.NewTaskEvent(structs.TaskSetup).SetMessage(large)
+		event.Details = map[string]string{"i": fmt.Sprintf("%d", i)}
+		ar.appendTaskEvent(state, event)
+	}
+
+	require.Len(state.Events, 2)
+	require.Equal("3", state.Events[0].Details["i"])
+	require.Equal("4", state.Events[1].Details["i"])
+
+	size := 0
+	for _, e := range state.Events {
+		size += taskEventSize(e)
+	}
+	require.True(size <= taskEventBytesBudget)
+
+	// A single event over the budget is kept
+	huge := strings.Repeat("a", 2*taskEventBytesBudget)
+	ar.appendTaskEvent(state, structs.NewTaskEvent(structs.TaskSetup).SetMessage(huge))
+	require.Len(state.Events, 1)
+	require.Equal(huge, state.Events[0].Message)
+}
+
+// TestAllocRunner_RestoreTaskEvents asserts restored events are kept within
+// the event capacity without counting restarts.
+func TestAllocRunner_RestoreTaskEvents(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ar := &AllocRunner{taskStates: make(map[string]*structs.TaskState)}
+
+	events := make([]*structs.TaskEvent, 15)
+	for i := range events {
+		events[i] = structs.NewTaskEvent(structs.TaskRestarting)
+		events[i].Details = map[string]string{"i": fmt.Sprintf("%d", i)}
+	}
+	ar.RestoreTaskEvents("web", events)
+
+	state := ar.taskStates["web"]
+	require.Len(state.Events, taskEventCapacity)
+	for i, e := range state.Events {
+		require.Equal(fmt.Sprintf("%d", i+5), e.Details["i"])
+	}
+	require.Zero(state.Restarts)
+	require.Empty(state.State)
+}
+
 // Test that the watcher will mark the allocation as unhealthy.
+// TestAllocRunner_PendingMetric asserts the pending counter is incremented
+// when a task transitions into pending because it is restarting.
+func TestAllocRunner_PendingMetric(t *testing.T) {
+	require := require.New(t)
+
+	// Capture metrics in memory
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	_, err := metrics.NewGlobal(conf, sink)
+	require.NoError(err)
+	defer metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+
+	_, ar := TestAllocRunner(t, false)
+	ar.alloc.Job.Name = uuid.Generate()
+	ar.setBaseLabels()
+	taskName := ar.alloc.Job.TaskGroups[0].Tasks[0].Name
+
+	pending := func() int {
+		count := 0
+		for _, intv := range sink.Data() {
+			for _, c := range intv.Counters {
+				if c.Name != "client.allocs.pending" {
+					continue
+				}
+				for _, l := range c.Labels {
+					if l.Name == "job" && l.Value == ar.alloc.Job.Name {
+						count += c.Count
+					}
+				}
+			}
+		}
+		return count
+	}
+
+	// Start the task which must not count as pending
+	ar.setTaskState(taskName, structs.TaskStateRunning, structs.NewTaskEvent(structs.TaskStarted), false)
+	require.Equal(0, pending())
+
+	// Restart the task
+	restart := structs.NewTaskEvent(structs.TaskRestarting).SetRestartDelay(time.Second)
+	ar.setTaskState(taskName, structs.TaskStatePending, restart, false)
+	require.Equal(1, pending())
+
+	// Staying pending must not count again
+	ar.setTaskState(taskName, structs.TaskStatePending, structs.NewTaskEvent(structs.TaskDownloadingArtifacts), false)
+	require.Equal(1, pending())
+
+	// Disabling tagged metrics disables the counter
+	ar.config.DisableTaggedMetrics = true
+	ar.setTaskState(taskName, structs.TaskStateRunning, structs.NewTaskEvent(structs.TaskStarted), false)
+	ar.setTaskState(taskName, structs.TaskStatePending, restart, false)
+	require.Equal(1, pending())
+}
+
+// mockAttributeSink records the attributes emitted for each task state
+type mockAttributeSink struct {
+	l      sync.Mutex
+	states map[string][]config.Attribute
+}
+
+func (m *mockAttributeSink) EmitTaskState(state string, attrs []config.Attribute) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.states[state] = attrs
+}
+
+func (m *mockAttributeSink) get(state string) []config.Attribute {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.states[state]
+}
+
+// TestAllocRunner_AttributeSink asserts the configured attribute sink receives
+// the task's identity when the task starts running.
+func TestAllocRunner_AttributeSink(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	sink := &mockAttributeSink{states: make(map[string][]config.Attribute)}
+	_, ar := TestAllocRunnerFromAlloc(t, alloc, false)
+	ar.config.AttributeSink = sink
+	go ar.Run()
+	defer ar.Destroy()
+
+	testutil.WaitForResult(func() (bool, error) {
+		if attrs := sink.get(structs.TaskStateRunning); attrs == nil {
+			return false, fmt.Errorf("no attributes emitted for running task")
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	expected := []config.Attribute{
+		{Key: "nomad.job.name", Value: alloc.Job.Name},
+		{Key: "nomad.task_group.name", Value: alloc.TaskGroup},
+		{Key: "nomad.alloc.id", Value: alloc.ID},
+		{Key: "nomad.task.name", Value: task.Name},
+		{Key: "nomad.node.id", Value: ar.config.Node.ID},
+	}
+	require.Equal(expected, sink.get(structs.TaskStateRunning))
+}
+
 func TestAllocRunner_DeploymentHealth_Unhealthy_BadStart(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -612,6 +892,50 @@ func TestAllocRunner_RetryArtifact(t *testing.T) {
 	})
 }
 
+// TestAllocRunner_Shutdown asserts shutting down the alloc runner makes its
+// task runners exit without killing their tasks.
+func TestAllocRunner_Shutdown(t *testing.T) {
+	t.Parallel()
+	upd, ar := TestAllocRunner(t, false)
+
+	task := ar.alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config["run_for"] = "10s"
+	go ar.Run()
+	defer ar.Destroy()
+
+	testutil.WaitForResult(func() (bool, error) {
+		last := upd.Last()
+		if last == nil {
+			return false, fmt.Errorf("No updates")
+		}
+		if last.ClientStatus != structs.AllocClientStatusRunning {
+			return false, fmt.Errorf("got status %v; want %v", last.ClientStatus, structs.AllocClientStatusRunning)
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	ar.Shutdown()
+
+	for _, tr := range ar.getTaskRunners() {
+		select {
+		case <-tr.WaitCh():
+		default:
+			t.Fatalf("task runner has not exited")
+		}
+		if res := tr.ExitResult(); res == nil || res.Err != taskrunner.ErrShutdown {
+			t.Fatalf("expected shutdown exit result; got %v", res)
+		}
+	}
+
+	// The task should have been left running
+	if state := ar.Alloc().TaskStates[task.Name]; state.State != structs.TaskStateRunning {
+		t.Fatalf("got state %v; want %v", state.State, structs.TaskStateRunning)
+	}
+}
+
 func TestAllocRunner_TerminalUpdate_Destroy(t *testing.T) {
 	t.Parallel()
 	upd, ar := TestAllocRunner(t, false)
@@ -870,6 +1194,154 @@ func TestAllocRunner_SaveRestoreState(t *testing.T) {
 	}
 }
 
+// TestAllocRunner_SaveRestoreState_DriverInitFailed asserts a restored task
+// whose driver can't be created is failed and the other tasks are killed.
+func TestAllocRunner_SaveRestoreState_DriverInitFailed(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.KillTimeout = 10 * time.Millisecond
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	task2 := task.Copy()
+	task2.Name = "task 2"
+	alloc.Job.TaskGroups[0].Tasks = append(alloc.Job.TaskGroups[0].Tasks, task2)
+	alloc.TaskResources[task2.Name] = task2.Resources
+
+	upd, ar := TestAllocRunnerFromAlloc(t, alloc, false)
+	go ar.Run()
+
+	testutil.WaitForResult(func() (bool, error) {
+		last := upd.Last()
+		if last == nil {
+			return false, fmt.Errorf("No updates")
+		}
+		if last.ClientStatus != structs.AllocClientStatusRunning {
+			return false, fmt.Errorf("got status %v; want %v", last.ClientStatus, structs.AllocClientStatusRunning)
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Make the second task's driver unavailable once restored
+	ar.allocLock.Lock()
+	saved := ar.alloc.Copy()
+	saved.EvalID = uuid.Generate()
+	saved.Job.TaskGroups[0].Tasks[1].Driver = "unknown_driver"
+	ar.alloc = saved
+	ar.allocLock.Unlock()
+
+	if err := ar.SaveState(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Create a new alloc runner
+	l2 := testlog.WithPrefix(t, "----- ar2:  ")
+	alloc2 := &structs.Allocation{ID: ar.alloc.ID}
+	prevAlloc := NewAllocWatcher(alloc2, ar, nil, ar.config, l2, "")
+	ar2 := NewAllocRunner(l2, ar.config, ar.stateDB, upd.Update,
+		alloc2, ar.vaultClient, ar.consulClient, prevAlloc)
+	if err := ar2.RestoreState(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	go ar2.Run()
+
+	testutil.WaitForResult(func() (bool, error) {
+		last := upd.Last()
+		if last.ClientStatus != structs.AllocClientStatusFailed {
+			return false, fmt.Errorf("got status %v; want %v", last.ClientStatus, structs.AllocClientStatusFailed)
+		}
+
+		// The restored task should be killed
+		state1 := last.TaskStates[task.Name]
+		if state1 == nil || state1.State != structs.TaskStateDead {
+			return false, fmt.Errorf("got state %v; want %v", state1, structs.TaskStateDead)
+		}
+		found := false
+		for _, e := range state1.Events {
+			if e.Type == structs.TaskSiblingFailed && e.FailedSibling == task2.Name {
+				found = true
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("Did not find event %v", structs.TaskSiblingFailed)
+		}
+
+		// The task that couldn't be restored should be failed
+		state2 := last.TaskStates[task2.Name]
+		if state2 == nil || state2.State != structs.TaskStateDead {
+			return false, fmt.Errorf("got state %v; want %v", state2, structs.TaskStateDead)
+		}
+		if !state2.Failed {
+			return false, fmt.Errorf("task2 should have failed")
+		}
+		return true, nil
+	}, func(err error) {
+		last := upd.Last()
+		t.Fatalf("err: %v %#v", err, last.TaskStates)
+	})
+
+	for _, r := range []*AllocRunner{ar, ar2} {
+		r.Destroy()
+		select {
+		case <-r.WaitCh():
+		case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+			t.Fatalf("timeout waiting for alloc runner to exit")
+		}
+	}
+}
+
+// TestAllocRunner_SaveRestoreState_TaskTimes asserts a running task's start
+// time is restored from persisted state.
+func TestAllocRunner_SaveRestoreState_TaskTimes(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "0",
+		"run_for":   "10s",
+	}
+
+	upd, ar := TestAllocRunnerFromAlloc(t, alloc, false)
+	go ar.Run()
+	defer ar.Destroy()
+
+	// Wait for the task to be running
+	var startedAt time.Time
+	testutil.WaitForResult(func() (bool, error) {
+		state := ar.Alloc().TaskStates[task.Name]
+		if state == nil || state.State != structs.TaskStateRunning {
+			return false, fmt.Errorf("task not running: %#v", state)
+		}
+		startedAt = state.StartedAt
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+	require.False(startedAt.IsZero())
+	require.NoError(ar.SaveState())
+
+	// Restore into a new alloc runner
+	l2 := testlog.WithPrefix(t, "----- ar2:  ")
+	alloc2 := &structs.Allocation{ID: ar.alloc.ID}
+	prevAlloc := NewAllocWatcher(alloc2, ar, nil, ar.config, l2, "")
+	ar2 := NewAllocRunner(l2, ar.config, ar.stateDB, upd.Update,
+		alloc2, ar.vaultClient, ar.consulClient, prevAlloc)
+	require.NoError(ar2.RestoreState())
+
+	state := ar2.Alloc().TaskStates[task.Name]
+	require.NotNil(state)
+	require.Equal(structs.TaskStateRunning, state.State)
+	require.True(startedAt.Equal(state.StartedAt), "expected %v; got %v", startedAt, state.StartedAt)
+	require.True(state.FinishedAt.IsZero())
+}
+
 func TestAllocRunner_SaveRestoreState_TerminalAlloc(t *testing.T) {
 	t.Parallel()
 	upd, ar := TestAllocRunner(t, false)
@@ -1061,6 +1533,82 @@ func TestAllocRunner_TaskFailed_KillTG(t *testing.T) {
 	})
 }
 
+// TestAllocRunner_DriverInitFailed_KillTG asserts that tasks already started
+// are killed and the remaining tasks aren't started when a task's driver can't
+// be created.
+func TestAllocRunner_DriverInitFailed_KillTG(t *testing.T) {
+	t.Parallel()
+	upd, ar := TestAllocRunner(t, false)
+
+	// Create three tasks in the task group with the second one's driver
+	// failing to be created
+	task := ar.alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.KillTimeout = 10 * time.Millisecond
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	task2 := task.Copy()
+	task2.Name = "task 2"
+	task2.Driver = "unknown_driver"
+
+	task3 := task.Copy()
+	task3.Name = "task 3"
+
+	ar.alloc.Job.TaskGroups[0].Tasks = append(ar.alloc.Job.TaskGroups[0].Tasks, task2, task3)
+	ar.alloc.TaskResources[task2.Name] = task2.Resources
+	ar.alloc.TaskResources[task3.Name] = task3.Resources
+	go ar.Run()
+	defer ar.Destroy()
+
+	testutil.WaitForResult(func() (bool, error) {
+		last := upd.Last()
+		if last == nil {
+			return false, fmt.Errorf("No updates")
+		}
+		if last.ClientStatus != structs.AllocClientStatusFailed {
+			return false, fmt.Errorf("got status %v; want %v", last.ClientStatus, structs.AllocClientStatusFailed)
+		}
+
+		// Task One should be killed
+		state1 := last.TaskStates[task.Name]
+		if state1 == nil || state1.State != structs.TaskStateDead {
+			return false, fmt.Errorf("got state %v; want %v", state1, structs.TaskStateDead)
+		}
+		found := false
+		for _, e := range state1.Events {
+			if e.Type == structs.TaskSiblingFailed && e.FailedSibling == task2.Name {
+				found = true
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("Did not find event %v", structs.TaskSiblingFailed)
+		}
+
+		// Task Two should be failed
+		state2 := last.TaskStates[task2.Name]
+		if state2 == nil || state2.State != structs.TaskStateDead {
+			return false, fmt.Errorf("got state %v; want %v", state2, structs.TaskStateDead)
+		}
+		if !state2.Failed {
+			return false, fmt.Errorf("task2 should have failed")
+		}
+
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Task Three should never have been started
+	ar.taskLock.RLock()
+	_, ok := ar.tasks[task3.Name]
+	ar.taskLock.RUnlock()
+	if ok {
+		t.Fatalf("task 3 should not have been started")
+	}
+}
+
 func TestAllocRunner_TaskLeader_KillTG(t *testing.T) {
 	t.Parallel()
 	upd, ar := TestAllocRunner(t, false)
@@ -1256,13 +1804,21 @@ func TestAllocRunner_TaskLeader_StopRestoredTG(t *testing.T) {
 	ar.alloc.TaskResources[task2.Name] = task2.Resources
 
 	// Mimic Nomad exiting before the leader stopping is able to stop other tasks.
+	leaderTR, err := taskrunner.NewTaskRunner(ar.logger, ar.config, ar.stateDB, ar.setTaskState,
+		ar.allocDir.NewTaskDir(task2.Name), ar.Alloc(), task2.Copy(),
+		ar.vaultClient, ar.consulClient)
+	if err != nil {
+		t.Fatalf("error creating task runner: %v", err)
+	}
+	followerTR, err := taskrunner.NewTaskRunner(ar.logger, ar.config, ar.stateDB, ar.setTaskState,
+		ar.allocDir.NewTaskDir(task.Name), ar.Alloc(), task.Copy(),
+		ar.vaultClient, ar.consulClient)
+	if err != nil {
+		t.Fatalf("error creating task runner: %v", err)
+	}
 	ar.tasks = map[string]*taskrunner.TaskRunner{
-		"leader": taskrunner.NewTaskRunner(ar.logger, ar.config, ar.stateDB, ar.setTaskState,
-			ar.allocDir.NewTaskDir(task2.Name), ar.Alloc(), task2.Copy(),
-			ar.vaultClient, ar.consulClient),
-		"follower1": taskrunner.NewTaskRunner(ar.logger, ar.config, ar.stateDB, ar.setTaskState,
-			ar.allocDir.NewTaskDir(task.Name), ar.Alloc(), task.Copy(),
-			ar.vaultClient, ar.consulClient),
+		"leader":    leaderTR,
+		"follower1": followerTR,
 	}
 	ar.taskStates = map[string]*structs.TaskState{
 		"leader":    {State: structs.TaskStateDead},
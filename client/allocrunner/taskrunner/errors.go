@@ -0,0 +1,41 @@
+package taskrunner
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMissingTaskGroup is returned when the allocation's job does not
+	// contain the allocation's task group. It is not recoverable and the
+	// allocation should be failed.
+	ErrMissingTaskGroup = errors.New("alloc missing task group")
+
+	// ErrShutdown is the error of the wait result returned by ExitResult when
+	// the task runner was shutdown rather than the task exiting.
+	ErrShutdown = errors.New("task runner shutdown")
+)
+
+// DriverInitError wraps the error returned when creating the task's driver.
+type DriverInitError struct {
+	// Driver is the name of the driver that failed to be created
+	Driver string
+
+	// AllocID is the ID of the allocation the task belongs to
+	AllocID string
+
+	// Err is the underlying driver error
+	Err error
+}
+
+func newDriverInitError(driver, allocID string, err error) *DriverInitError {
+	return &DriverInitError{
+		Driver:  driver,
+		AllocID: allocID,
+		Err:     err,
+	}
+}
+
+func (e *DriverInitError) Error() string {
+	return fmt.Sprintf("failed to create driver '%s' for alloc %s: %v", e.Driver, e.AllocID, e.Err)
+}
@@ -0,0 +1,48 @@
+package restarts
+
+import (
+	dstructs "github.com/hashicorp/nomad/client/driver/structs"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// StartErrorClass is the classification of an error starting a task, used to
+// decide whether and how soon the task is restarted.
+type StartErrorClass string
+
+const (
+	// StartErrorConfig is an error the driver didn't mark as recoverable,
+	// such as an error in the task's configuration. Restarting the task won't
+	// help so it is not restarted.
+	StartErrorConfig StartErrorClass = "config"
+
+	// StartErrorTransient is an error that may not happen again, such as a
+	// network failure. The task is restarted according to its policy.
+	StartErrorTransient StartErrorClass = "transient"
+
+	// StartErrorResource is an error caused by the node running out of a
+	// resource. The task is restarted after a longer delay to let the node
+	// recover.
+	StartErrorResource StartErrorClass = "resource"
+
+	// StartErrorAuth is an error authenticating, such as pulling an image
+	// with invalid credentials. The task is not restarted.
+	StartErrorAuth StartErrorClass = "auth"
+)
+
+// ClassifyStartError classifies an error starting a task. Errors that aren't
+// recoverable fail the task: they are authentication errors if the driver
+// returned an AuthError and configuration errors otherwise. Recoverable errors
+// are resource errors if the driver returned a ResourceError and transient
+// otherwise.
+func ClassifyStartError(err error) StartErrorClass {
+	if !structs.IsRecoverable(err) {
+		if _, ok := err.(*dstructs.AuthError); ok {
+			return StartErrorAuth
+		}
+		return StartErrorConfig
+	}
+	if _, ok := err.(*dstructs.ResourceError); ok {
+		return StartErrorResource
+	}
+	return StartErrorTransient
+}
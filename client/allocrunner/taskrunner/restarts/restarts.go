@@ -14,8 +14,13 @@ const (
 	// jitter is the percent of jitter added to restart delays.
 	jitter = 0.25
 
+	// resourceErrorDelay is the minimum delay before restarting a task that
+	// failed to start because the node ran out of a resource.
+	resourceErrorDelay = 30 * time.Second
+
 	ReasonNoRestartsAllowed   = "Policy allows no restarts"
 	ReasonUnrecoverableErrror = "Error was unrecoverable"
+	ReasonAuthError           = "Error was an authentication failure"
 	ReasonWithinPolicy        = "Restart within policy"
 	ReasonDelay               = "Exceeded allowed attempts, applying a delay"
 )
@@ -36,6 +41,7 @@ func NewRestartTracker(policy *structs.RestartPolicy, jobType string) *RestartTr
 type RestartTracker struct {
 	waitRes          *dstructs.WaitResult
 	startErr         error
+	startErrClass    StartErrorClass // Classification of startErr
 	restartTriggered bool      // Whether the task has been signalled to be restarted
 	failure          bool      // Whether a failure triggered the restart
 	count            int       // Current number of attempts.
@@ -67,6 +73,10 @@ func (r *RestartTracker) SetStartError(err error) *RestartTracker {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 	r.startErr = err
+	r.startErrClass = ""
+	if err != nil {
+		r.startErrClass = ClassifyStartError(err)
+	}
 	r.failure = true
 	return r
 }
@@ -95,6 +105,16 @@ func (r *RestartTracker) SetRestartTriggered(failure bool) *RestartTracker {
 	return r
 }
 
+// ResetAttempts clears the number of restart attempts and begins a new
+// interval. It is used when the task ran long enough to be considered stable.
+func (r *RestartTracker) ResetAttempts() *RestartTracker {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.count = 0
+	r.startTime = time.Now()
+	return r
+}
+
 // GetReason returns a human-readable description for the last state returned by
 // GetState.
 func (r *RestartTracker) GetReason() string {
@@ -120,6 +140,7 @@ func (r *RestartTracker) GetState() (string, time.Duration) {
 	// Clear out the existing state
 	defer func() {
 		r.startErr = nil
+		r.startErrClass = ""
 		r.waitRes = nil
 		r.restartTriggered = false
 		r.failure = false
@@ -162,10 +183,15 @@ func (r *RestartTracker) GetState() (string, time.Duration) {
 	}
 
 	if r.startErr != nil {
-		// If the error is not recoverable, do not restart.
-		if !structs.IsRecoverable(r.startErr) {
+		// Configuration and authentication errors won't be fixed by
+		// restarting, so do not restart.
+		switch r.startErrClass {
+		case StartErrorConfig:
 			r.reason = ReasonUnrecoverableErrror
 			return structs.TaskNotRestarting, 0
+		case StartErrorAuth:
+			r.reason = ReasonAuthError
+			return structs.TaskNotRestarting, 0
 		}
 	} else if r.waitRes != nil {
 		// If the task started successfully and restart on success isn't specified,
@@ -187,12 +213,22 @@ func (r *RestartTracker) GetState() (string, time.Duration) {
 			return structs.TaskNotRestarting, 0
 		} else {
 			r.reason = ReasonDelay
-			return structs.TaskRestarting, r.getDelay()
+			return structs.TaskRestarting, r.getDelay() + r.delayJitter()
 		}
 	}
 
 	r.reason = ReasonWithinPolicy
-	return structs.TaskRestarting, r.jitter()
+
+	// Give the node time to recover when it ran out of a resource
+	if r.startErrClass == StartErrorResource && r.policy.Delay < resourceErrorDelay {
+		return structs.TaskRestarting, r.jitter(resourceErrorDelay)
+	}
+
+	// Use the initial delay for the first restart in the interval
+	if r.count == 1 && r.policy.InitialDelay > 0 {
+		return structs.TaskRestarting, r.jitter(r.policy.InitialDelay)
+	}
+	return structs.TaskRestarting, r.jitter(r.policy.Delay)
 }
 
 // getDelay returns the delay time to enter the next interval.
@@ -202,10 +238,18 @@ func (r *RestartTracker) getDelay() time.Duration {
 	return end.Sub(now)
 }
 
-// jitter returns the delay time plus a jitter.
-func (r *RestartTracker) jitter() time.Duration {
+// delayJitter returns a random duration up to the policy's jitter.
+func (r *RestartTracker) delayJitter() time.Duration {
+	if r.policy.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(r.rand.Int63n(r.policy.Jitter.Nanoseconds() + 1))
+}
+
+// jitter returns the given delay time plus a jitter.
+func (r *RestartTracker) jitter(delay time.Duration) time.Duration {
 	// Get the delay and ensure it is valid.
-	d := r.policy.Delay.Nanoseconds()
+	d := delay.Nanoseconds()
 	if d == 0 {
 		d = 1
 	}
@@ -2,6 +2,7 @@ package restarts
 
 import (
 	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -55,6 +56,40 @@ func TestClient_RestartTracker_ModeDelay(t *testing.T) {
 	}
 }
 
+func TestClient_RestartTracker_ModeDelay_Jitter(t *testing.T) {
+	t.Parallel()
+	p := testPolicy(true, structs.RestartPolicyModeDelay)
+	p.Jitter = 1 * time.Minute
+	rt := NewRestartTracker(p, structs.JobTypeService)
+	rt.rand = rand.New(rand.NewSource(1))
+
+	// Exhaust the attempts
+	for i := 0; i < p.Attempts; i++ {
+		rt.SetWaitResult(testWaitResult(127)).GetState()
+	}
+
+	// Follow up restarts are delayed until the end of the interval plus a
+	// jitter
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 10; i++ {
+		state, when := rt.SetWaitResult(testWaitResult(127)).GetState()
+		if state != structs.TaskRestarting {
+			t.Fatalf("NextRestart() returned %v, want %v", state, structs.TaskRestarting)
+		}
+		if reason := rt.GetReason(); reason != ReasonDelay {
+			t.Fatalf("GetReason() returned %q; want %q", reason, ReasonDelay)
+		}
+		if when <= 0 || when > p.Interval+p.Jitter {
+			t.Fatalf("NextRestart() returned %v; want > 0 and <= %v", when, p.Interval+p.Jitter)
+		}
+		seen[when.Round(time.Second)] = struct{}{}
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("expected restart delays to vary within the jitter; got %v", seen)
+	}
+}
+
 func TestClient_RestartTracker_ModeFail(t *testing.T) {
 	t.Parallel()
 	p := testPolicy(true, structs.RestartPolicyModeFail)
@@ -75,6 +110,64 @@ func TestClient_RestartTracker_ModeFail(t *testing.T) {
 	}
 }
 
+func TestClient_RestartTracker_ResetAttempts(t *testing.T) {
+	t.Parallel()
+	p := testPolicy(true, structs.RestartPolicyModeFail)
+	rt := NewRestartTracker(p, structs.JobTypeService)
+
+	// Exhaust the attempts and reset them
+	for i := 0; i < p.Attempts; i++ {
+		rt.SetWaitResult(testWaitResult(127)).GetState()
+	}
+	rt.ResetAttempts()
+
+	// The budget has been reset so the task is restarted again
+	for i := 0; i < p.Attempts; i++ {
+		state, when := rt.SetWaitResult(testWaitResult(127)).GetState()
+		if state != structs.TaskRestarting {
+			t.Fatalf("NextRestart() returned %v, want %v", state, structs.TaskRestarting)
+		}
+		if !withinJitter(p.Delay, when) {
+			t.Fatalf("NextRestart() returned %v; want %v+jitter", when, p.Delay)
+		}
+	}
+
+	if state, _ := rt.SetWaitResult(testWaitResult(127)).GetState(); state != structs.TaskNotRestarting {
+		t.Fatalf("NextRestart() returned %v; want %v", state, structs.TaskNotRestarting)
+	}
+}
+
+func TestClient_RestartTracker_InitialDelay(t *testing.T) {
+	t.Parallel()
+	p := testPolicy(true, structs.RestartPolicyModeFail)
+	p.InitialDelay = 100 * time.Millisecond
+	rt := NewRestartTracker(p, structs.JobTypeService)
+
+	inRange := func(expected, actual time.Duration) bool {
+		return actual >= expected && float64(actual) <= float64(expected)*(1+jitter)
+	}
+
+	// The first restart uses the initial delay
+	state, when := rt.SetWaitResult(testWaitResult(127)).GetState()
+	if state != structs.TaskRestarting {
+		t.Fatalf("NextRestart() returned %v, want %v", state, structs.TaskRestarting)
+	}
+	if !inRange(p.InitialDelay, when) {
+		t.Fatalf("NextRestart() returned %v; want %v+jitter", when, p.InitialDelay)
+	}
+
+	// Later restarts use the policy delay
+	for i := 1; i < p.Attempts; i++ {
+		state, when := rt.SetWaitResult(testWaitResult(127)).GetState()
+		if state != structs.TaskRestarting {
+			t.Fatalf("NextRestart() returned %v, want %v", state, structs.TaskRestarting)
+		}
+		if !inRange(p.Delay, when) {
+			t.Fatalf("NextRestart() returned %v; want %v+jitter", when, p.Delay)
+		}
+	}
+}
+
 func TestClient_RestartTracker_NoRestartOnSuccess(t *testing.T) {
 	t.Parallel()
 	p := testPolicy(false, structs.RestartPolicyModeDelay)
@@ -185,3 +278,74 @@ func TestClient_RestartTracker_StartError_Recoverable_Delay(t *testing.T) {
 		t.Fatalf("NextRestart() returned %v; want > %v and <= %v", when, p.Delay, p.Interval)
 	}
 }
+
+func TestClient_RestartTracker_ClassifyStartError(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		err      error
+		expected StartErrorClass
+	}{
+		{fmt.Errorf("failed to parse config: unknown key %q", "imge"), StartErrorConfig},
+		{structs.NewRecoverableError(fmt.Errorf("connection reset by peer"), true), StartErrorTransient},
+		{cstructs.NewAuthError(fmt.Errorf("Failed to pull `redis`: unauthorized: authentication required")), StartErrorAuth},
+		{cstructs.NewResourceError(fmt.Errorf("write /alloc/data: no space left on device")), StartErrorResource},
+		{cstructs.WrapStartError("failed to start task", cstructs.NewResourceError(fmt.Errorf("out of memory"))), StartErrorResource},
+		{structs.WrapRecoverable("failed to start task", structs.NewRecoverableError(fmt.Errorf("timeout"), true)), StartErrorTransient},
+
+		// Only typed errors are classified as auth or resource errors
+		{fmt.Errorf("fork/exec /bin/sh: cannot allocate memory"), StartErrorConfig},
+		{structs.NewRecoverableError(fmt.Errorf("registry returned 403 forbidden, retrying"), true), StartErrorTransient},
+	}
+
+	for _, c := range cases {
+		if class := ClassifyStartError(c.err); class != c.expected {
+			t.Fatalf("ClassifyStartError(%q) returned %q; want %q", c.err, class, c.expected)
+		}
+	}
+}
+
+func TestClient_RestartTracker_StartError_Classes(t *testing.T) {
+	t.Parallel()
+	p := testPolicy(true, structs.RestartPolicyModeDelay)
+
+	// Configuration errors are not restarted
+	rt := NewRestartTracker(p, structs.JobTypeService)
+	if state, _ := rt.SetStartError(fmt.Errorf("invalid config")).GetState(); state != structs.TaskNotRestarting {
+		t.Fatalf("NextRestart() returned %v; want %v", state, structs.TaskNotRestarting)
+	}
+	if reason := rt.GetReason(); reason != ReasonUnrecoverableErrror {
+		t.Fatalf("GetReason() returned %q; want %q", reason, ReasonUnrecoverableErrror)
+	}
+
+	// Authentication errors are not restarted
+	rt = NewRestartTracker(p, structs.JobTypeService)
+	authErr := cstructs.NewAuthError(fmt.Errorf("unauthorized: authentication required"))
+	if state, _ := rt.SetStartError(authErr).GetState(); state != structs.TaskNotRestarting {
+		t.Fatalf("NextRestart() returned %v; want %v", state, structs.TaskNotRestarting)
+	}
+	if reason := rt.GetReason(); reason != ReasonAuthError {
+		t.Fatalf("GetReason() returned %q; want %q", reason, ReasonAuthError)
+	}
+
+	// Resource errors are restarted after a longer delay
+	rt = NewRestartTracker(p, structs.JobTypeService)
+	resErr := cstructs.NewResourceError(fmt.Errorf("no space left on device"))
+	state, when := rt.SetStartError(resErr).GetState()
+	if state != structs.TaskRestarting {
+		t.Fatalf("NextRestart() returned %v; want %v", state, structs.TaskRestarting)
+	}
+	if !withinJitter(resourceErrorDelay, when) || when < resourceErrorDelay {
+		t.Fatalf("NextRestart() returned %v; want %v+jitter", when, resourceErrorDelay)
+	}
+
+	// Transient errors are restarted according to the policy
+	rt = NewRestartTracker(p, structs.JobTypeService)
+	transientErr := structs.NewRecoverableError(fmt.Errorf("connection reset by peer"), true)
+	state, when = rt.SetStartError(transientErr).GetState()
+	if state != structs.TaskRestarting {
+		t.Fatalf("NextRestart() returned %v; want %v", state, structs.TaskRestarting)
+	}
+	if !withinJitter(p.Delay, when) {
+		t.Fatalf("NextRestart() returned %v; want %v+jitter", when, p.Delay)
+	}
+}
@@ -0,0 +1,120 @@
+package taskrunner
+
+import (
+	"reflect"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// taskDiff categorizes the fields that differ between two versions of a task
+// by whether the change can be applied to the running task.
+type taskDiff struct {
+	// restart are the fields whose changes only take effect once the task is
+	// restarted, such as its driver config and environment.
+	restart []string
+
+	// inPlace are the fields whose changes don't require the task to be
+	// restarted, such as its services which are updated in Consul.
+	inPlace []string
+}
+
+// requiresRestart returns whether any of the changes require the task to be
+// restarted to take effect.
+func (d *taskDiff) requiresRestart() bool {
+	return len(d.restart) > 0
+}
+
+// changed returns whether the field with the given name changed.
+func (d *taskDiff) changed(name string) bool {
+	for _, f := range d.restart {
+		if f == name {
+			return true
+		}
+	}
+	for _, f := range d.inPlace {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// empty returns whether the tasks are the same.
+func (d *taskDiff) empty() bool {
+	return len(d.restart) == 0 && len(d.inPlace) == 0
+}
+
+// diffTask returns the changes from the old to the new version of a task.
+// Fields are named as in the job specification. Restart-required fields match
+// the ones the scheduler's tasksUpdated treats as destructive updates. Changes
+// to constraints and the leader flag also require a restart as they can
+// invalidate the placement or change how sibling tasks are killed.
+func diffTask(old, new *structs.Task) *taskDiff {
+	d := &taskDiff{}
+	restart := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			d.restart = append(d.restart, name)
+		}
+	}
+	inPlace := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			d.inPlace = append(d.inPlace, name)
+		}
+	}
+
+	restart("driver", old.Driver, new.Driver)
+	restart("user", old.User, new.User)
+	restart("config", old.Config, new.Config)
+	restart("env", old.Env, new.Env)
+	restart("artifact", old.Artifacts, new.Artifacts)
+	restart("vault", old.Vault, new.Vault)
+	restart("template", old.Templates, new.Templates)
+	restart("meta", old.Meta, new.Meta)
+	if resourcesUpdated(old.Resources, new.Resources) {
+		d.restart = append(d.restart, "resources")
+	}
+	restart("constraint", old.Constraints, new.Constraints)
+	restart("leader", old.Leader, new.Leader)
+
+	inPlace("service", old.Services, new.Services)
+	inPlace("kill_timeout", old.KillTimeout, new.KillTimeout)
+	inPlace("kill_signal", old.KillSignal, new.KillSignal)
+	inPlace("shutdown_delay", old.ShutdownDelay, new.ShutdownDelay)
+	inPlace("logs", old.LogConfig, new.LogConfig)
+	return d
+}
+
+// resourcesUpdated returns whether the resources changed in a way the
+// scheduler treats as destructive: the networks' bandwidth or ports, or the
+// CPU, memory or IOPS. The values of dynamic ports are ignored.
+func resourcesUpdated(a, b *structs.Resources) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	if len(a.Networks) != len(b.Networks) {
+		return true
+	}
+	for i := range a.Networks {
+		an, bn := a.Networks[i], b.Networks[i]
+		if an.MBits != bn.MBits {
+			return true
+		}
+		if !reflect.DeepEqual(networkPortMap(an), networkPortMap(bn)) {
+			return true
+		}
+	}
+	return a.CPU != b.CPU || a.MemoryMB != b.MemoryMB || a.IOPS != b.IOPS
+}
+
+// networkPortMap returns a map of port labels to values. The value of dynamic
+// ports is disregarded even if it is set.
+func networkPortMap(n *structs.NetworkResource) map[string]int {
+	m := make(map[string]int, len(n.DynamicPorts)+len(n.ReservedPorts))
+	for _, p := range n.ReservedPorts {
+		m[p.Label] = p.Value
+	}
+	for _, p := range n.DynamicPorts {
+		m[p.Label] = -1
+	}
+	return m
+}
@@ -0,0 +1,104 @@
+package taskrunner
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+func TestTaskDiff(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		update  func(*structs.Task)
+		restart []string
+		inPlace []string
+	}{
+		{
+			name:   "none",
+			update: func(*structs.Task) {},
+		},
+		{
+			name: "env",
+			update: func(task *structs.Task) {
+				task.Env["BAR"] = "baz"
+			},
+			restart: []string{"env"},
+		},
+		{
+			name: "service",
+			update: func(task *structs.Task) {
+				task.Services[0].Tags = []string{"new"}
+			},
+			inPlace: []string{"service"},
+		},
+		{
+			name: "image",
+			update: func(task *structs.Task) {
+				task.Config["image"] = "redis:4"
+			},
+			restart: []string{"config"},
+		},
+		{
+			name: "image and service",
+			update: func(task *structs.Task) {
+				task.Config["image"] = "redis:4"
+				task.Services[0].Tags = []string{"new"}
+				task.KillTimeout = 30 * time.Second
+			},
+			restart: []string{"config"},
+			inPlace: []string{"service", "kill_timeout"},
+		},
+		{
+			name: "constraint and leader",
+			update: func(task *structs.Task) {
+				task.Constraints = append(task.Constraints, &structs.Constraint{
+					LTarget: "${attr.kernel.name}",
+					RTarget: "linux",
+					Operand: "=",
+				})
+				task.Leader = true
+			},
+			restart: []string{"constraint", "leader"},
+		},
+		{
+			name: "memory",
+			update: func(task *structs.Task) {
+				task.Resources.MemoryMB = 1024
+			},
+			restart: []string{"resources"},
+		},
+		{
+			name: "dynamic port value",
+			update: func(task *structs.Task) {
+				task.Resources.Networks[0].DynamicPorts[0].Value = 23456
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			old := mock.Job().TaskGroups[0].Tasks[0]
+			new := old.Copy()
+			c.update(new)
+
+			d := diffTask(old, new)
+			if !reflect.DeepEqual(d.restart, c.restart) {
+				t.Fatalf("expected restart-required changes %v but found %v", c.restart, d.restart)
+			}
+			if !reflect.DeepEqual(d.inPlace, c.inPlace) {
+				t.Fatalf("expected in-place changes %v but found %v", c.inPlace, d.inPlace)
+			}
+			if d.requiresRestart() != (len(c.restart) > 0) {
+				t.Fatalf("expected requiresRestart to be %t", len(c.restart) > 0)
+			}
+			if d.empty() != (len(c.restart) == 0 && len(c.inPlace) == 0) {
+				t.Fatalf("unexpected empty result %t", d.empty())
+			}
+		})
+	}
+}
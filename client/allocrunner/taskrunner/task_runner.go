@@ -2,16 +2,20 @@ package taskrunner
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	metrics "github.com/armon/go-metrics"
@@ -38,18 +42,22 @@ import (
 )
 
 const (
-	// killBackoffBaseline is the baseline time for exponential backoff while
-	// killing a task.
+	// killBackoffBaseline is the default baseline time for exponential
+	// backoff while killing a task.
 	killBackoffBaseline = 5 * time.Second
 
-	// killBackoffLimit is the limit of the exponential backoff for killing
-	// the task.
+	// killBackoffLimit is the default limit of the exponential backoff for
+	// killing the task.
 	killBackoffLimit = 2 * time.Minute
 
 	// killFailureLimit is how many times we will attempt to kill a task before
 	// giving up and potentially leaking resources.
 	killFailureLimit = 5
 
+	// drainKillImminent is how close a drain deadline must be before a task
+	// killed with KillWithDeadline is hard killed without a graceful window.
+	drainKillImminent = 1 * time.Second
+
 	// vaultBackoffBaseline is the baseline time for exponential backoff when
 	// attempting to retrieve a Vault token
 	vaultBackoffBaseline = 5 * time.Second
@@ -61,6 +69,19 @@ const (
 	// vaultTokenFile is the name of the file holding the Vault token inside the
 	// task's secret directory
 	vaultTokenFile = "vault_token"
+
+	// TaskLivenessMessage is the driver message of the events periodically
+	// emitted while a task is running. Only the newest is kept in the task's
+	// events.
+	TaskLivenessMessage = "Task still running"
+
+	// memoryPressureEventInterval is the minimum interval between the events
+	// emitted while a task's memory usage is above the pressure threshold.
+	memoryPressureEventInterval = 5 * time.Minute
+
+	// eventSinkBufferSize is the default number of task events buffered for the
+	// configured EventSink before events are dropped.
+	eventSinkBufferSize = 64
 )
 
 var (
@@ -69,6 +90,43 @@ var (
 	taskRunnerStateAllKey = []byte("simple-all")
 )
 
+// Phases of the task runner's run loop as returned by Phase.
+const (
+	// TaskPhasePending is the phase of a task runner that hasn't been run.
+	TaskPhasePending = "pending"
+
+	// TaskPhasePrestart is the phase while the task is being prepared and
+	// started.
+	TaskPhasePrestart = "prestart"
+
+	// TaskPhaseRunning is the phase while the task is running.
+	TaskPhaseRunning = "running"
+
+	// TaskPhaseRestartWait is the phase while waiting to restart the task.
+	TaskPhaseRestartWait = "restart-wait"
+
+	// TaskPhasePaused is the phase while the task is paused.
+	TaskPhasePaused = "paused"
+
+	// TaskPhaseStopping is the phase while the task is being stopped and
+	// cleaned up.
+	TaskPhaseStopping = "stopping"
+
+	// TaskPhaseExited is the phase once the run loop has exited.
+	TaskPhaseExited = "exited"
+)
+
+// Phases of the hooks reported by CurrentHook.
+const (
+	// HookPhasePrestart is the phase of the steps preparing the task to be
+	// started, such as downloading artifacts and rendering templates.
+	HookPhasePrestart = "prestart"
+
+	// HookPhaseNetwork is the phase of the network hooks called once the task
+	// is started.
+	HookPhaseNetwork = "network"
+)
+
 // taskRestartEvent wraps a TaskEvent with additional metadata to control
 // restart behavior.
 type taskRestartEvent struct {
@@ -99,6 +157,17 @@ type TaskRunner struct {
 	running     bool
 	runningLock sync.Mutex
 
+	// phase is the current phase of the run loop
+	phase     string
+	phaseLock sync.Mutex
+
+	// currentHook is the name and phase of the hook currently executing and
+	// currentHookSince when it started. They are guarded by currentHookLock.
+	currentHook      string
+	currentHookPhase string
+	currentHookSince time.Time
+	currentHookLock  sync.Mutex
+
 	resourceUsage     *cstructs.TaskResourceUsage
 	resourceUsageLock sync.RWMutex
 
@@ -106,6 +175,11 @@ type TaskRunner struct {
 	task    *structs.Task
 	taskDir *allocdir.TaskDir
 
+	// allocID and taskName are immutable so they may be read without
+	// synchronizing with updates to alloc and task
+	allocID  string
+	taskName string
+
 	// envBuilder is used to build the task's environment
 	envBuilder *env.Builder
 
@@ -119,6 +193,13 @@ type TaskRunner struct {
 	handle     driver.DriverHandle
 	handleLock sync.Mutex
 
+	// driver is created along with the task runner and used to prepare and
+	// start the task. driverName and driverAbilities describe it. They are
+	// set when the task runner is created and not modified after.
+	driver          driver.Driver
+	driverName      string
+	driverAbilities driver.DriverAbilities
+
 	// artifactsDownloaded tracks whether the tasks artifacts have been
 	// downloaded
 	//
@@ -130,6 +211,27 @@ type TaskRunner struct {
 	// Must acquire persistLock when accessing
 	taskDirBuilt bool
 
+	// paused tracks whether the task has been paused and must not be started
+	// until it is resumed.
+	//
+	// Must acquire persistLock when accessing
+	paused bool
+
+	// pausePending is set when the task is paused while it isn't running,
+	// such as during prestart or while waiting to restart. The pause is
+	// applied once the task is running or the restart wait ends. It isn't
+	// persisted.
+	//
+	// Must acquire persistLock when accessing
+	pausePending bool
+
+	// templatesChanged is set when an update changes the task's templates so
+	// they are rendered again the next time the task is started. Unchanged
+	// templates are not rendered again when the task is restarted.
+	//
+	// Must acquire persistLock when accessing
+	templatesChanged bool
+
 	// createdResources are all the resources created by the task driver
 	// across all attempts to start the task.
 	// Simple gets and sets should use {get,set}CreatedResources
@@ -165,14 +267,76 @@ type TaskRunner struct {
 	// signalCh is used to send a signal to a task
 	signalCh chan SignalEvent
 
+	// pauseCh and resumeCh are used to pause and resume the task
+	pauseCh  chan struct{}
+	resumeCh chan struct{}
+
+	// updateHooks restart the task when they fire. They must be registered
+	// with AddUpdateHook before Run is called.
+	updateHooks []TaskUpdateHook
+
+	// networkHooks may replace the network returned by the driver. They must
+	// be registered with AddNetworkHook before Run is called.
+	networkHooks []TaskNetworkHook
+
 	destroy      bool
 	destroyCh    chan struct{}
 	destroyLock  sync.Mutex
 	destroyEvent *structs.TaskEvent
 
+	// killDeadline is the drain deadline the task must be killed by. It is
+	// set by KillWithDeadline and guarded by destroyLock.
+	killDeadline time.Time
+
+	// killTimeoutWarned marks whether the capped kill timeout warning has
+	// been emitted. It is only accessed by the run loop.
+	killTimeoutWarned bool
+
+	// shutdownCh is closed to make the run loop exit without killing the
+	// task, as is done when the agent is shutting down.
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+
+	// restartReason is the reason given by the restart tracker for the last
+	// restart decision and restarting marks whether the task is waiting to
+	// be restarted. Both are guarded by restartReasonLock.
+	restartReason     string
+	restarting        bool
+	restartReasonLock sync.Mutex
+
+	// exitResult is the last wait result of the task or the shutdown
+	// sentinel. It is read by ExitResult once waitCh is closed.
+	exitResult     *dstructs.WaitResult
+	exitResultLock sync.Mutex
+
+	// exitState is the last wait result returned by the driver handle. Unlike
+	// exitResult it is never the shutdown sentinel. It is guarded by
+	// exitResultLock.
+	exitState *dstructs.WaitResult
+
 	// waitCh closing marks the run loop as having exited
 	waitCh chan struct{}
 
+	// runStarted is set once Run is called and must be accessed atomically
+	runStarted int32
+
+	// ctx is cancelled when the run loop exits
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	// eventSinkCh buffers task events for the configured EventSink. It is nil
+	// if there is no sink. droppedSinkEvents counts the events dropped
+	// because the buffer was full and must be accessed atomically.
+	// eventSinkDoneCh is closed once the buffered events have been emitted.
+	// eventSinkClosed is set under eventSinkLock once the remaining events
+	// are being emitted, after which new events are dropped.
+	eventSinkCh       chan *structs.TaskEvent
+	eventSinkDoneCh   chan struct{}
+	eventSinkClosed   bool
+	eventSinkLock     sync.Mutex
+	droppedSinkEvents uint64
+
 	// persistLock must be acquired when accessing fields stored by
 	// SaveState. SaveState is called asynchronously to TaskRunner.Run by
 	// AllocRunner, so all state fields must be synchronized using this
@@ -183,13 +347,23 @@ type TaskRunner struct {
 	// detect if a new snapshot has to be written to disk.
 	persistedHash []byte
 
+	// newStateHash creates the hash used to detect changes to the persisted
+	// snapshot. It defaults to md5 and is replaced in tests.
+	newStateHash func() hash.Hash
+
+	// persistTimer is pending while a debounced write of the state is
+	// delayed by the TaskStateDebounce. It is guarded by persistTimerLock.
+	persistTimer     *time.Timer
+	persistTimerLock sync.Mutex
+
 	// baseLabels are used when emitting tagged metrics. All task runner metrics
 	// will have these tags, and optionally more.
 	baseLabels []metrics.Label
 }
 
-// taskRunnerState is used to snapshot the state of the task runner
-type taskRunnerState struct {
+// LocalState is the node-local state of the task runner that is persisted
+// to the state db.
+type LocalState struct {
 	Version            string
 	HandleID           string
 	ArtifactDownloaded bool
@@ -197,16 +371,23 @@ type taskRunnerState struct {
 	PayloadRendered    bool
 	CreatedResources   *driver.CreatedResources
 	DriverNetwork      *cstructs.DriverNetwork
+	Paused             bool
+}
+
+func (s *LocalState) Hash() []byte {
+	return s.hashWith(md5.New)
 }
 
-func (s *taskRunnerState) Hash() []byte {
-	h := md5.New()
+// hashWith returns a hash of the local state using the given hash constructor.
+func (s *LocalState) hashWith(newHash func() hash.Hash) []byte {
+	h := newHash()
 
 	io.WriteString(h, s.Version)
 	io.WriteString(h, s.HandleID)
 	io.WriteString(h, fmt.Sprintf("%v", s.ArtifactDownloaded))
 	io.WriteString(h, fmt.Sprintf("%v", s.TaskDirBuilt))
 	io.WriteString(h, fmt.Sprintf("%v", s.PayloadRendered))
+	io.WriteString(h, fmt.Sprintf("%v", s.Paused))
 	h.Write(s.CreatedResources.Hash())
 	h.Write(s.DriverNetwork.Hash())
 
@@ -229,11 +410,37 @@ type SignalEvent struct {
 	result chan<- error
 }
 
-// NewTaskRunner is used to create a new task context
+// TaskUpdateHook is implemented by components that watch inputs of a task,
+// such as secrets, and require the task to be restarted when they change.
+type TaskUpdateHook interface {
+	// Name returns the name of the hook. It is used as the source of the
+	// restart.
+	Name() string
+
+	// UpdateCh returns a channel that receives the reason for restarting the
+	// task each time a watched input changes.
+	UpdateCh() <-chan string
+}
+
+// TaskNetworkHook is implemented by components that override or augment the
+// network returned by the task's driver before it is advertised.
+type TaskNetworkHook interface {
+	// Name returns the name of the hook.
+	Name() string
+
+	// UpdateNetwork is called with a copy of the driver's network, which may
+	// be nil, once the task is started. The returned network is used for the
+	// task's environment and service registrations.
+	UpdateNetwork(net *cstructs.DriverNetwork) (*cstructs.DriverNetwork, error)
+}
+
+// NewTaskRunner is used to create a new task context. ErrMissingTaskGroup is
+// returned if the allocation's task group can't be found and a
+// *DriverInitError if the task's driver can't be created.
 func NewTaskRunner(logger *log.Logger, config *config.Config,
 	stateDB *bolt.DB, updater TaskStateUpdater, taskDir *allocdir.TaskDir,
 	alloc *structs.Allocation, task *structs.Task,
-	vaultClient vaultclient.VaultClient, consulClient consulApi.ConsulServiceAPI) *TaskRunner {
+	vaultClient vaultclient.VaultClient, consulClient consulApi.ConsulServiceAPI) (*TaskRunner, error) {
 
 	// Merge in the task resources
 	task.Resources = alloc.TaskResources[task.Name]
@@ -242,7 +449,7 @@ func NewTaskRunner(logger *log.Logger, config *config.Config,
 	tg := alloc.Job.LookupTaskGroup(alloc.TaskGroup)
 	if tg == nil {
 		logger.Printf("[ERR] client: alloc %q for missing task group %q", alloc.ID, alloc.TaskGroup)
-		return nil
+		return nil, ErrMissingTaskGroup
 	}
 	restartTracker := restarts.NewRestartTracker(tg.RestartPolicy, alloc.Job.Type)
 
@@ -258,6 +465,8 @@ func NewTaskRunner(logger *log.Logger, config *config.Config,
 		alloc:            alloc,
 		task:             task,
 		taskDir:          taskDir,
+		allocID:          alloc.ID,
+		taskName:         task.Name,
 		envBuilder:       envBuilder,
 		createdResources: driver.NewCreatedResources(),
 		consul:           consulClient,
@@ -265,11 +474,31 @@ func NewTaskRunner(logger *log.Logger, config *config.Config,
 		vaultFuture:      NewTokenFuture().Set(""),
 		updateCh:         make(chan *structs.Allocation, 64),
 		destroyCh:        make(chan struct{}),
+		shutdownCh:       make(chan struct{}),
+		pauseCh:          make(chan struct{}, 1),
+		resumeCh:         make(chan struct{}),
 		waitCh:           make(chan struct{}),
 		startCh:          make(chan struct{}, 1),
 		unblockCh:        make(chan struct{}),
 		restartCh:        make(chan *taskRestartEvent),
 		signalCh:         make(chan SignalEvent),
+		phase:            TaskPhasePending,
+		newStateHash:     md5.New,
+	}
+
+	parentCtx := context.Background()
+	if config.BaseContext != nil {
+		parentCtx = config.BaseContext
+	}
+	tc.ctx, tc.ctxCancel = context.WithCancel(parentCtx)
+
+	if config.EventSink != nil {
+		size := config.EventSinkBufferSize
+		if size <= 0 {
+			size = eventSinkBufferSize
+		}
+		tc.eventSinkCh = make(chan *structs.TaskEvent, size)
+		tc.eventSinkDoneCh = make(chan struct{})
 	}
 
 	tc.baseLabels = []metrics.Label{
@@ -308,17 +537,38 @@ func NewTaskRunner(logger *log.Logger, config *config.Config,
 				Value: strings.Split(tc.alloc.Job.Name, "/periodic-")[1],
 			})
 		}
-		return tc
 	}
 
-	return tc
+	// Create the driver now so callers can fail fast
+	d, err := tc.createDriver()
+	if err != nil {
+		logger.Printf("[ERR] client: alloc %q task %q: %v", alloc.ID, task.Name, err)
+		return nil, err
+	}
+	tc.driver = d
+	tc.driverName = task.Driver
+	tc.driverAbilities = d.Abilities()
+
+	return tc, nil
+}
+
+// DriverName returns the name of the driver backing the task.
+func (r *TaskRunner) DriverName() string {
+	return r.driverName
+}
+
+// DriverCapabilities returns the abilities of the driver backing the task.
+func (r *TaskRunner) DriverCapabilities() driver.DriverAbilities {
+	return r.driverAbilities
 }
 
 // MarkReceived marks the task as received.
 func (r *TaskRunner) MarkReceived() {
 	// We lazy sync this since there will be a follow up message almost
 	// immediately.
-	r.updater(r.task.Name, structs.TaskStatePending, structs.NewTaskEvent(structs.TaskReceived), true)
+	event := structs.NewTaskEvent(structs.TaskReceived)
+	r.updater(r.task.Name, structs.TaskStatePending, event, true)
+	r.sinkEvent(event)
 }
 
 // WaitCh returns a channel to wait for termination
@@ -326,6 +576,154 @@ func (r *TaskRunner) WaitCh() <-chan struct{} {
 	return r.waitCh
 }
 
+// WaitForExit blocks until the task runner exits or the context is done, in
+// which case the context's error is returned.
+func (r *TaskRunner) WaitForExit(ctx context.Context) error {
+	select {
+	case <-r.waitCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AllocID returns the ID of the allocation the task belongs to.
+func (r *TaskRunner) AllocID() string {
+	return r.allocID
+}
+
+// TaskName returns the name of the task.
+func (r *TaskRunner) TaskName() string {
+	return r.taskName
+}
+
+// Context returns a context that is cancelled when the run loop exits, either
+// because the task stopped or because the task runner was shutdown for an
+// agent restart. Hooks can use it to tie the lifetime of the goroutines they
+// spawn to the task. It derives from the client's BaseContext if set, so
+// values such as trace IDs are visible through it.
+func (r *TaskRunner) Context() context.Context {
+	return r.ctx
+}
+
+// Running returns whether the task is running. It reflects the last state set
+// by the run loop and is cheaper than snapshotting the task state.
+func (r *TaskRunner) Running() bool {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	return r.running
+}
+
+// ExitResult returns the last wait result of the task and should be called
+// after WaitCh is closed. If the task runner was shutdown the result wraps
+// ErrShutdown. Nil is returned if the task never exited.
+func (r *TaskRunner) ExitResult() *dstructs.WaitResult {
+	r.exitResultLock.Lock()
+	defer r.exitResultLock.Unlock()
+	return r.exitResult
+}
+
+// ExitState returns a copy of the wait result of the last time the task
+// exited, including its exit code and signal. Nil is returned if the task has
+// not exited yet.
+func (r *TaskRunner) ExitState() *dstructs.WaitResult {
+	r.exitResultLock.Lock()
+	defer r.exitResultLock.Unlock()
+	return r.exitState.Copy()
+}
+
+// RestartReason returns the reason given by the restart tracker for the last
+// decision on whether to restart the task.
+func (r *TaskRunner) RestartReason() string {
+	r.restartReasonLock.Lock()
+	defer r.restartReasonLock.Unlock()
+	return r.restartReason
+}
+
+// Restarting returns whether the task is waiting to be restarted, which
+// distinguishes a task pending a restart from one pending its first start.
+func (r *TaskRunner) Restarting() bool {
+	r.restartReasonLock.Lock()
+	defer r.restartReasonLock.Unlock()
+	return r.restarting
+}
+
+// RestartPolicy returns a copy of the restart policy currently used to
+// determine whether the task is restarted.
+func (r *TaskRunner) RestartPolicy() *structs.RestartPolicy {
+	return r.restartTracker.GetPolicy()
+}
+
+// setRestarting sets whether the task is waiting to be restarted.
+func (r *TaskRunner) setRestarting(restarting bool) {
+	r.restartReasonLock.Lock()
+	r.restarting = restarting
+	r.restartReasonLock.Unlock()
+}
+
+// Phase returns the current phase of the task runner's run loop. See the
+// TaskPhase constants.
+func (r *TaskRunner) Phase() string {
+	r.phaseLock.Lock()
+	defer r.phaseLock.Unlock()
+	return r.phase
+}
+
+// setPhase sets the current phase of the run loop.
+func (r *TaskRunner) setPhase(phase string) {
+	r.phaseLock.Lock()
+	r.phase = phase
+	r.phaseLock.Unlock()
+}
+
+// CurrentHook returns the name and phase of the hook currently executing and
+// when it started, or empty values if no hook is executing. See the HookPhase
+// constants.
+func (r *TaskRunner) CurrentHook() (name, phase string, since time.Time) {
+	r.currentHookLock.Lock()
+	defer r.currentHookLock.Unlock()
+	return r.currentHook, r.currentHookPhase, r.currentHookSince
+}
+
+// setCurrentHook records the hook that is starting to execute.
+func (r *TaskRunner) setCurrentHook(name, phase string) {
+	r.currentHookLock.Lock()
+	r.currentHook = name
+	r.currentHookPhase = phase
+	r.currentHookSince = time.Now()
+	r.currentHookLock.Unlock()
+}
+
+// clearCurrentHook clears the current hook if it belongs to the given phase,
+// so a phase finishing late doesn't clear the hook of a later phase.
+func (r *TaskRunner) clearCurrentHook(phase string) {
+	r.currentHookLock.Lock()
+	if r.currentHookPhase == phase {
+		r.currentHook = ""
+		r.currentHookPhase = ""
+		r.currentHookSince = time.Time{}
+	}
+	r.currentHookLock.Unlock()
+}
+
+// setExitResult stores the last wait result of the task.
+func (r *TaskRunner) setExitResult(res *dstructs.WaitResult) {
+	r.exitResultLock.Lock()
+	r.exitResult = res
+	r.exitResultLock.Unlock()
+}
+
+// setExitState stores the wait result returned by the driver handle when the
+// task exits, and records it as the exit result.
+func (r *TaskRunner) setExitState(res *dstructs.WaitResult) {
+	r.exitResultLock.Lock()
+	r.exitResult = res
+	if res != nil {
+		r.exitState = res
+	}
+	r.exitResultLock.Unlock()
+}
+
 // getHandle returns the task's handle or nil
 func (r *TaskRunner) getHandle() driver.DriverHandle {
 	r.handleLock.Lock()
@@ -334,6 +732,43 @@ func (r *TaskRunner) getHandle() driver.DriverHandle {
 	return h
 }
 
+// setDriverHandleIfNil sets the task's handle only if it does not already
+// have one. It returns whether the handle was set, preventing both a recovery
+// and a fresh start from setting a handle.
+func (r *TaskRunner) setDriverHandleIfNil(h driver.DriverHandle) bool {
+	r.handleLock.Lock()
+	if r.handle != nil {
+		r.handleLock.Unlock()
+		return false
+	}
+	r.handle = h
+	r.handleLock.Unlock()
+
+	r.handleChanged(nil, h)
+	return true
+}
+
+// clearDriverHandle clears the task's handle so a new driver will be created
+// the next time the task is started.
+func (r *TaskRunner) clearDriverHandle() {
+	r.handleLock.Lock()
+	old := r.handle
+	r.handle = nil
+	r.handleLock.Unlock()
+
+	if old != nil {
+		r.handleChanged(old, nil)
+	}
+}
+
+// handleChanged calls the client's OnHandleChange func, if any. It must not be
+// called while holding the handleLock.
+func (r *TaskRunner) handleChanged(old, new driver.DriverHandle) {
+	if r.config.OnHandleChange != nil {
+		r.config.OnHandleChange(r.allocID, r.taskName, old, new)
+	}
+}
+
 // pre060StateFilePath returns the path to our state file that would have been
 // written pre v0.6.0
 // COMPAT: Remove in 0.7.0
@@ -352,7 +787,7 @@ func (r *TaskRunner) pre060StateFilePath() string {
 // backwards incompatible upgrades that need to restart tasks with a new
 // executor.
 func (r *TaskRunner) RestoreState() (string, error) {
-	var snap taskRunnerState
+	var snap LocalState
 	err := r.stateDB.View(func(tx *bolt.Tx) error {
 		bkt, err := state.GetTaskBucket(tx, r.alloc.ID, r.task.Name)
 		if err != nil {
@@ -372,6 +807,7 @@ func (r *TaskRunner) RestoreState() (string, error) {
 	r.artifactsDownloaded = snap.ArtifactDownloaded
 	r.taskDirBuilt = snap.TaskDirBuilt
 	r.payloadRendered = snap.PayloadRendered
+	r.paused = snap.Paused
 	r.setCreatedResources(snap.CreatedResources)
 	r.driverNet = snap.DriverNetwork
 
@@ -425,9 +861,11 @@ func (r *TaskRunner) RestoreState() (string, error) {
 				r.task.Name, r.alloc.ID, err)
 		}
 
-		r.handleLock.Lock()
-		r.handle = handle
-		r.handleLock.Unlock()
+		if !r.setDriverHandleIfNil(handle) {
+			r.logger.Printf("[WARN] client: not restoring handle to task %q for alloc %q: task already has a handle",
+				r.task.Name, r.alloc.ID)
+			return restartReason, nil
+		}
 
 		r.runningLock.Lock()
 		r.running = true
@@ -469,8 +907,16 @@ func pre06ScriptCheck(ver, driver string, services []*structs.Service) bool {
 	return false
 }
 
-// SaveState is used to snapshot our state
+// SaveState is used to snapshot our state. Any pending debounced write is
+// replaced by this one.
 func (r *TaskRunner) SaveState() error {
+	r.persistTimerLock.Lock()
+	if r.persistTimer != nil {
+		r.persistTimer.Stop()
+		r.persistTimer = nil
+	}
+	r.persistTimerLock.Unlock()
+
 	r.destroyLock.Lock()
 	defer r.destroyLock.Unlock()
 	if r.destroy {
@@ -480,33 +926,17 @@ func (r *TaskRunner) SaveState() error {
 
 	r.persistLock.Lock()
 	defer r.persistLock.Unlock()
-	snap := taskRunnerState{
-		Version:            r.config.Version.VersionNumber(),
-		ArtifactDownloaded: r.artifactsDownloaded,
-		TaskDirBuilt:       r.taskDirBuilt,
-		PayloadRendered:    r.payloadRendered,
-		CreatedResources:   r.getCreatedResources(),
-	}
-
-	r.handleLock.Lock()
-	if r.handle != nil {
-		snap.HandleID = r.handle.ID()
-	}
-	r.handleLock.Unlock()
-
-	r.driverNetLock.Lock()
-	snap.DriverNetwork = r.driverNet.Copy()
-	r.driverNetLock.Unlock()
+	snap := r.localState()
 
 	// If nothing has changed avoid the write
-	h := snap.Hash()
+	h := snap.hashWith(r.newStateHash)
 	if bytes.Equal(h, r.persistedHash) {
 		return nil
 	}
 
 	// Serialize the object
 	var buf bytes.Buffer
-	if err := codec.NewEncoder(&buf, structs.MsgpackHandle).Encode(&snap); err != nil {
+	if err := codec.NewEncoder(&buf, structs.MsgpackHandle).Encode(snap); err != nil {
 		return fmt.Errorf("failed to serialize snapshot: %v", err)
 	}
 
@@ -522,15 +952,95 @@ func (r *TaskRunner) SaveState() error {
 			return fmt.Errorf("failed to write task_runner state: %v", err)
 		}
 
-		// Store the hash that was persisted
+		// Store the hash that was persisted and report the size of the state
+		size := buf.Len()
 		tx.OnCommit(func() {
 			r.persistedHash = h
+			if !r.config.DisableTaggedMetrics && !r.config.AllocMetrics.Paused() {
+				metrics.SetGaugeWithLabels([]string{"client", "allocs", "state_size_bytes"},
+					float32(size), r.baseLabels)
+			}
 		})
 
 		return nil
 	})
 }
 
+// persistState saves the state of the task runner. If TaskStateDebounce is
+// set the write is delayed so a burst of state changes is coalesced into one
+// write.
+func (r *TaskRunner) persistState() {
+	if r.config.TaskStateDebounce <= 0 {
+		if err := r.SaveState(); err != nil {
+			r.logger.Printf("[ERR] client: failed to save state of Task Runner for task %q: %v", r.task.Name, err)
+		}
+		return
+	}
+
+	r.persistTimerLock.Lock()
+	defer r.persistTimerLock.Unlock()
+	if r.persistTimer != nil {
+		// A write is already pending and will include this change
+		return
+	}
+	r.persistTimer = time.AfterFunc(r.config.TaskStateDebounce, func() {
+		r.persistTimerLock.Lock()
+		r.persistTimer = nil
+		r.persistTimerLock.Unlock()
+
+		if err := r.SaveState(); err != nil {
+			r.logger.Printf("[ERR] client: failed to save state of Task Runner for task %q: %v", r.task.Name, err)
+		}
+	})
+}
+
+// flushState immediately writes the state if a debounced write is pending.
+func (r *TaskRunner) flushState() {
+	r.persistTimerLock.Lock()
+	pending := r.persistTimer != nil
+	r.persistTimerLock.Unlock()
+
+	if !pending {
+		return
+	}
+	if err := r.SaveState(); err != nil {
+		r.logger.Printf("[ERR] client: failed to save state of Task Runner for task %q: %v", r.task.Name, err)
+	}
+}
+
+// localState builds a snapshot of the task runner's local state. The
+// persistLock must be held.
+func (r *TaskRunner) localState() *LocalState {
+	snap := &LocalState{
+		Version:            r.config.Version.VersionNumber(),
+		ArtifactDownloaded: r.artifactsDownloaded,
+		TaskDirBuilt:       r.taskDirBuilt,
+		PayloadRendered:    r.payloadRendered,
+		CreatedResources:   r.getCreatedResources(),
+		Paused:             r.paused,
+	}
+
+	r.handleLock.Lock()
+	if r.handle != nil {
+		snap.HandleID = r.handle.ID()
+	}
+	r.handleLock.Unlock()
+
+	r.driverNetLock.Lock()
+	snap.DriverNetwork = r.driverNet.Copy()
+	r.driverNetLock.Unlock()
+
+	return snap
+}
+
+// LocalStateSnapshot returns a copy of the local state persisted by the task
+// runner for debugging. Modifying it does not affect the task runner.
+func (r *TaskRunner) LocalStateSnapshot() *LocalState {
+	r.persistLock.Lock()
+	defer r.persistLock.Unlock()
+	return r.localState()
+}
+
 // DestroyState is used to cleanup after ourselves
 func (r *TaskRunner) DestroyState() error {
 	r.persistLock.Lock()
@@ -549,15 +1059,90 @@ func (r *TaskRunner) setState(state string, event *structs.TaskEvent, lazySync b
 	event.PopulateEventDisplayMessage()
 
 	// Persist our state to disk.
-	if err := r.SaveState(); err != nil {
-		r.logger.Printf("[ERR] client: failed to save state of Task Runner for task %q: %v", r.task.Name, err)
-	}
+	r.persistState()
 
 	// Indicate the task has been updated.
 	r.updater(r.task.Name, state, event, lazySync)
+	r.sinkEvent(event)
+}
+
+// sinkEvent queues the event for the configured EventSink. If the buffer is
+// full the event is dropped and counted, unless EventSinkBlock is set in which
+// case it waits for room while the buffer is being drained. Events queued
+// after the remaining events have been emitted are also dropped and counted.
+func (r *TaskRunner) sinkEvent(event *structs.TaskEvent) {
+	if r.eventSinkCh == nil || event == nil {
+		return
+	}
+
+	event = event.Copy()
+	r.eventSinkLock.Lock()
+	defer r.eventSinkLock.Unlock()
+	if r.eventSinkClosed {
+		r.dropSinkEvent(event, "event sink closed")
+		return
+	}
+
+	if r.config.EventSinkBlock {
+		select {
+		case r.eventSinkCh <- event:
+			return
+		case <-r.waitCh:
+			// The buffer is no longer drained once the run loop exits
+		}
+	}
+
+	select {
+	case r.eventSinkCh <- event:
+	default:
+		r.dropSinkEvent(event, "event sink buffer full")
+	}
+}
+
+// dropSinkEvent counts and logs an event that won't be emitted to the
+// EventSink.
+func (r *TaskRunner) dropSinkEvent(event *structs.TaskEvent, reason string) {
+	dropped := atomic.AddUint64(&r.droppedSinkEvents, 1)
+	r.logger.Printf("[WARN] client: dropping event %q of task %q for alloc %q: %s (%d dropped)",
+		event.Type, r.taskName, r.allocID, reason, dropped)
+}
+
+// DroppedSinkEvents returns the number of task events that were not delivered
+// to the configured EventSink because its buffer was full or the task runner
+// had exited.
+func (r *TaskRunner) DroppedSinkEvents() uint64 {
+	return atomic.LoadUint64(&r.droppedSinkEvents)
 }
 
-// createDriver makes a driver for the task
+// runEventSink should be called in a go-routine and delivers the buffered task
+// events to the configured EventSink in order. Once the task runner exits the
+// sink is closed to new events and the remaining buffered events are
+// delivered.
+func (r *TaskRunner) runEventSink() {
+	defer close(r.eventSinkDoneCh)
+	sink := r.config.EventSink
+	for {
+		select {
+		case event := <-r.eventSinkCh:
+			sink.EmitTaskEvent(r.allocID, r.taskName, event)
+		case <-r.waitCh:
+			r.eventSinkLock.Lock()
+			r.eventSinkClosed = true
+			r.eventSinkLock.Unlock()
+			for {
+				select {
+				case event := <-r.eventSinkCh:
+					sink.EmitTaskEvent(r.allocID, r.taskName, event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// createDriver makes a driver for the task. Errors are returned as a
+// *DriverInitError.
 func (r *TaskRunner) createDriver() (driver.Driver, error) {
 	// Create a task-specific event emitter callback to expose minimal
 	// state to drivers
@@ -570,16 +1155,21 @@ func (r *TaskRunner) createDriver() (driver.Driver, error) {
 	driverCtx := driver.NewDriverContext(r.alloc.Job.Name, r.alloc.TaskGroup, r.task.Name, r.alloc.ID, r.config, r.config.Node, r.logger, eventEmitter)
 	d, err := driver.NewDriver(r.task.Driver, driverCtx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create driver '%s' for alloc %s: %v",
-			r.task.Driver, r.alloc.ID, err)
+		return nil, newDriverInitError(r.task.Driver, r.alloc.ID, err)
 	}
 
-	return d, err
+	return d, nil
 }
 
 // Run is a long running routine used to manage the task
 func (r *TaskRunner) Run() {
+	atomic.StoreInt32(&r.runStarted, 1)
 	defer close(r.waitCh)
+	defer r.stop()
+	if r.eventSinkCh != nil {
+		go r.runEventSink()
+	}
+
 	r.logger.Printf("[DEBUG] client: starting task context for '%s' (alloc '%s')",
 		r.task.Name, r.alloc.ID)
 
@@ -591,23 +1181,10 @@ func (r *TaskRunner) Run() {
 		return
 	}
 
-	// Create a temporary driver so that we can determine the FSIsolation
-	// required. run->startTask will create a new driver after environment
-	// has been setup (env vars, templates, artifacts, secrets, etc).
-	tmpDrv, err := r.createDriver()
-	if err != nil {
-		e := fmt.Errorf("failed to create driver of task %q for alloc %q: %v", r.task.Name, r.alloc.ID, err)
-		r.setState(
-			structs.TaskStateDead,
-			structs.NewTaskEvent(structs.TaskSetupFailure).SetSetupError(e).SetFailsTask(),
-			false)
-		return
-	}
-
 	// Build base task directory structure regardless of FS isolation abilities.
 	// This needs to happen before we start the Vault manager and call prestart
 	// as both those can write to the task directories
-	if err := r.buildTaskDir(tmpDrv.FSIsolation()); err != nil {
+	if err := r.buildTaskDir(r.driver.FSIsolation()); err != nil {
 		e := fmt.Errorf("failed to build task directory for %q: %v", r.task.Name, err)
 		r.setState(
 			structs.TaskStateDead,
@@ -624,15 +1201,89 @@ func (r *TaskRunner) Run() {
 		go r.vaultManager(r.recoveredVaultToken)
 	}
 
+	// Start watching for updates requiring a restart
+	for _, h := range r.updateHooks {
+		go r.watchUpdateHook(h)
+	}
+
 	// Start the run loop
 	r.run()
 
-	// Do any cleanup necessary
-	r.postrun()
-
 	return
 }
 
+// stop does any cleanup necessary once the run loop exits and flushes the
+// task's state. Each step recovers from panics so a failure while stopping
+// doesn't prevent waitCh from being closed and wedge the alloc runner.
+func (r *TaskRunner) stop() {
+	r.setPhase(TaskPhaseStopping)
+	r.recoverStop("postrun", r.postrun)
+	r.ctxCancel()
+	r.setPhase(TaskPhaseExited)
+	r.recoverStop("flush state", r.flushState)
+}
+
+// recoverStop calls f and logs any panic it causes.
+func (r *TaskRunner) recoverStop(name string, f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			r.logger.Printf("[ERR] client: panic in %s while stopping task %q for alloc %q: %v\n%s",
+				name, r.task.Name, r.alloc.ID, err, debug.Stack())
+		}
+	}()
+	f()
+}
+
+// AddUpdateHook registers a hook that restarts the task each time it fires.
+// These restarts are not counted against the restart policy. It must be
+// called before Run.
+func (r *TaskRunner) AddUpdateHook(h TaskUpdateHook) {
+	r.updateHooks = append(r.updateHooks, h)
+}
+
+// AddNetworkHook registers a hook that may replace the driver's network each
+// time the task is started. Hooks are called in the order they are added. It
+// must be called before Run.
+func (r *TaskRunner) AddNetworkHook(h TaskNetworkHook) {
+	r.networkHooks = append(r.networkHooks, h)
+}
+
+// runNetworkHooks passes the driver's network through the network hooks and
+// returns the network to advertise.
+func (r *TaskRunner) runNetworkHooks(net *cstructs.DriverNetwork) (*cstructs.DriverNetwork, error) {
+	defer r.clearCurrentHook(HookPhaseNetwork)
+	for _, h := range r.networkHooks {
+		r.setCurrentHook(h.Name(), HookPhaseNetwork)
+		updated, err := h.UpdateNetwork(net.Copy())
+		if err != nil {
+			return nil, fmt.Errorf("network hook %q failed: %v", h.Name(), err)
+		}
+		net = updated
+	}
+	return net, nil
+}
+
+// watchUpdateHook should be called in a go-routine and restarts the task each
+// time the hook fires until the run loop exits.
+func (r *TaskRunner) watchUpdateHook(h TaskUpdateHook) {
+	updateCh := h.UpdateCh()
+	for {
+		select {
+		case reason, ok := <-updateCh:
+			if !ok {
+				return
+			}
+
+			r.logger.Printf("[DEBUG] client: update hook %q restarting task %q for alloc %q: %s",
+				h.Name(), r.task.Name, r.alloc.ID, reason)
+			const noFailure = false
+			r.Restart(h.Name(), reason, noFailure)
+		case <-r.waitCh:
+			return
+		}
+	}
+}
+
 // validateTask validates the fields of the task and returns an error if the
 // task is invalid.
 func (r *TaskRunner) validateTask() error {
@@ -940,8 +1591,11 @@ func (r *TaskRunner) updatedTokenHandler() {
 // Since it's run asynchronously with the main Run() loop the alloc & task are
 // passed in to avoid racing with updates.
 func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, resultCh chan bool) {
+	defer r.clearCurrentHook(HookPhasePrestart)
+
 	if task.Vault != nil {
 		// Wait for the token
+		r.setCurrentHook("vault", HookPhasePrestart)
 		r.logger.Printf("[DEBUG] client: waiting for Vault token for task %v in alloc %q", task.Name, alloc.ID)
 		tokenCh := r.vaultFuture.Wait()
 		select {
@@ -949,6 +1603,9 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 		case <-r.waitCh:
 			resultCh <- false
 			return
+		case <-r.shutdownCh:
+			// The run loop exits on shutdown so don't report a failure
+			return
 		}
 		r.logger.Printf("[DEBUG] client: retrieved Vault token for task %v in alloc %q", task.Name, alloc.ID)
 		r.envBuilder.SetVaultToken(r.vaultFuture.Get(), task.Vault.Env)
@@ -958,6 +1615,7 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 	requirePayload := len(alloc.Job.Payload) != 0 &&
 		(r.task.DispatchPayload != nil && r.task.DispatchPayload.File != "")
 	if !r.payloadRendered && requirePayload {
+		r.setCurrentHook("dispatch_payload", HookPhasePrestart)
 		renderTo := filepath.Join(r.taskDir.LocalDir, task.DispatchPayload.File)
 		decoded, err := snappy.Decode(nil, alloc.Job.Payload)
 		if err != nil {
@@ -993,10 +1651,13 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 	for {
 		r.persistLock.Lock()
 		downloaded := r.artifactsDownloaded
+		templatesChanged := r.templatesChanged
+		r.templatesChanged = false
 		r.persistLock.Unlock()
 
 		// Download the task's artifacts
 		if !downloaded && len(task.Artifacts) > 0 {
+			r.setCurrentHook("artifacts", HookPhasePrestart)
 			r.setState(structs.TaskStatePending, structs.NewTaskEvent(structs.TaskDownloadingArtifacts), false)
 			taskEnv := r.envBuilder.Build()
 			for _, artifact := range task.Artifacts {
@@ -1015,9 +1676,18 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 			r.persistLock.Unlock()
 		}
 
+		// Stop rendering the old templates if they changed and wait for the
+		// new ones to be rendered
+		if templatesChanged && r.templateManager != nil {
+			r.templateManager.Stop()
+			r.templateManager = nil
+			r.resetUnblock()
+		}
+
 		// We don't have to wait for any template
 		if len(task.Templates) == 0 {
 			// Send the start signal
+			r.clearCurrentHook(HookPhasePrestart)
 			select {
 			case r.startCh <- struct{}{}:
 			default:
@@ -1028,6 +1698,7 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 		}
 
 		// Build the template manager
+		r.setCurrentHook("template", HookPhasePrestart)
 		if r.templateManager == nil {
 			var err error
 			r.templateManager, err = NewTaskTemplateManager(&TaskTemplateManagerConfig{
@@ -1054,6 +1725,7 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 		select {
 		case <-r.unblockCh:
 			// Send the start signal
+			r.clearCurrentHook(HookPhasePrestart)
 			select {
 			case r.startCh <- struct{}{}:
 			default:
@@ -1065,10 +1737,18 @@ func (r *TaskRunner) prestart(alloc *structs.Allocation, task *structs.Task, res
 			// The run loop has exited so exit too
 			resultCh <- false
 			return
+		case <-r.shutdownCh:
+			// The run loop exits on shutdown so don't report a failure
+			return
 		}
 
 	RESTART:
-		restart := r.shouldRestart()
+		r.clearCurrentHook(HookPhasePrestart)
+		restart, shutdown := r.shouldRestart()
+		if shutdown {
+			// The run loop exits on shutdown so don't report a failure
+			return
+		}
 		if !restart {
 			resultCh <- false
 			return
@@ -1101,8 +1781,51 @@ func (r *TaskRunner) run() {
 		handleWaitCh = r.handle.WaitCh()
 	}
 
+	// Periodically show the task is still running if enabled
+	var livenessCh <-chan time.Time
+	if r.config.TaskLivenessInterval > 0 {
+		ticker := time.NewTicker(r.config.TaskLivenessInterval)
+		defer ticker.Stop()
+		livenessCh = ticker.C
+	}
+
+	// Warn about tasks that run past the watchdog timeout without exiting
+	var watchdog *time.Timer
+	var watchdogCh <-chan time.Time
+	defer func() {
+		if watchdog != nil {
+			watchdog.Stop()
+		}
+	}()
+
+	// startedAt is when the task was last started or restored, used to reset
+	// the restart attempts of stable tasks
+	var startedAt time.Time
+
 	for {
+		// Reset the watchdog for the next start of the task
+		if watchdog != nil {
+			watchdog.Stop()
+			watchdog = nil
+			watchdogCh = nil
+		}
+		startedAt = time.Time{}
+
+		// Hold the task while it is paused
+		if resumed, destroyed := r.waitWhilePaused(); !resumed {
+			if destroyed {
+				r.setPhase(TaskPhaseStopping)
+				r.cleanup()
+				r.setState(structs.TaskStateDead, r.destroyEvent, false)
+			} else {
+				r.setExitResult(dstructs.NewWaitResult(-1, 0, ErrShutdown))
+			}
+			return
+		}
+		paused := false
+
 		// Do the prestart activities
+		r.setPhase(TaskPhasePrestart)
 		prestartResultCh := make(chan bool, 1)
 		go r.prestart(r.alloc, r.task, prestartResultCh)
 
@@ -1111,6 +1834,7 @@ func (r *TaskRunner) run() {
 			select {
 			case success := <-prestartResultCh:
 				if !success {
+					r.setPhase(TaskPhaseStopping)
 					r.cleanup()
 					r.setState(structs.TaskStateDead, nil, false)
 					return
@@ -1129,7 +1853,7 @@ func (r *TaskRunner) run() {
 					}
 
 					// Mark the task as started
-					r.setState(structs.TaskStateRunning, structs.NewTaskEvent(structs.TaskStarted), false)
+					r.setState(structs.TaskStateRunning, structs.NewTaskEvent(structs.TaskStarted).SetSticky(), false)
 					r.runningLock.Lock()
 					r.running = true
 					r.runningLock.Unlock()
@@ -1139,7 +1863,21 @@ func (r *TaskRunner) run() {
 						go r.collectResourceUsageStats(stopCollection)
 					}
 
-					handleWaitCh = r.handle.WaitCh()
+					handleWaitCh = r.getHandle().WaitCh()
+				}
+				r.setPhase(TaskPhaseRunning)
+				if startedAt.IsZero() {
+					startedAt = time.Now()
+				}
+
+				// Apply a pause requested before the task was running
+				if r.isPausePending() {
+					r.notifyPause()
+				}
+
+				if timeout := r.watchdogTimeout(); timeout > 0 && watchdog == nil {
+					watchdog = time.NewTimer(timeout)
+					watchdogCh = watchdog.C
 				}
 
 			case waitRes := <-handleWaitCh:
@@ -1154,7 +1892,16 @@ func (r *TaskRunner) run() {
 				// Stop collection of the task's resource usage
 				close(stopCollection)
 
+				// Reset the restart attempts of tasks that ran long enough
+				// to be considered stable before exiting
+				if uptime := time.Since(startedAt); !startedAt.IsZero() && r.config.TaskStableUptime > 0 && uptime >= r.config.TaskStableUptime {
+					r.logger.Printf("[DEBUG] client: resetting restart attempts of task %q for alloc %q after uptime of %v",
+						r.task.Name, r.alloc.ID, uptime)
+					r.restartTracker.ResetAttempts()
+				}
+
 				// Log whether the task was successful or not.
+				r.setExitState(waitRes)
 				r.restartTracker.SetWaitResult(waitRes)
 				r.setState("", r.waitErrorToEvent(waitRes), true)
 				if !waitRes.Successful() {
@@ -1169,6 +1916,35 @@ func (r *TaskRunner) run() {
 					r.logger.Printf("[ERR] client: update to task %q failed: %v", r.task.Name, err)
 				}
 
+			case <-livenessCh:
+				r.runningLock.Lock()
+				running := r.running
+				r.runningLock.Unlock()
+				if running {
+					r.setState(structs.TaskStateRunning,
+						structs.NewTaskEvent(structs.TaskDriverMessage).SetDriverMessage(TaskLivenessMessage), true)
+				}
+
+			case <-watchdogCh:
+				r.runningLock.Lock()
+				running := r.running
+				r.runningLock.Unlock()
+				if !running {
+					continue
+				}
+
+				msg := fmt.Sprintf("Task has not exited after watchdog timeout of %v", r.watchdogTimeout())
+				r.logger.Printf("[WARN] client: task %q for alloc %q has not exited after watchdog timeout of %v",
+					r.task.Name, r.alloc.ID, r.watchdogTimeout())
+				r.setState(structs.TaskStateRunning,
+					structs.NewTaskEvent(structs.TaskDriverMessage).
+						SetDriverMessage(msg).
+						SetSeverity(structs.TaskEventSeverityWarn), false)
+				if r.config.TaskWatchdogKill {
+					const failure = true
+					r.Kill("watchdog", msg, failure)
+				}
+
 			case se := <-r.signalCh:
 				r.runningLock.Lock()
 				running := r.running
@@ -1211,6 +1987,7 @@ func (r *TaskRunner) run() {
 				break WAIT
 
 			case <-r.destroyCh:
+				r.setPhase(TaskPhaseStopping)
 				r.runningLock.Lock()
 				running := r.running
 				r.runningLock.Unlock()
@@ -1224,11 +2001,12 @@ func (r *TaskRunner) run() {
 				// can be rerouted
 				r.removeServices()
 
-				// Delay actually killing the task if configured. See #244
-				if r.task.ShutdownDelay > 0 {
+				// Delay actually killing the task if configured, but not
+				// past the drain deadline. See #244
+				if delay := r.shutdownDelay(time.Now()); delay > 0 {
 					r.logger.Printf("[DEBUG] client: delaying shutdown of alloc %q task %q for %q",
-						r.alloc.ID, r.task.Name, r.task.ShutdownDelay)
-					<-time.After(r.task.ShutdownDelay)
+						r.alloc.ID, r.task.Name, delay)
+					<-time.After(delay)
 				}
 
 				// Store the task event that provides context on the task
@@ -1247,35 +2025,105 @@ func (r *TaskRunner) run() {
 				close(stopCollection)
 
 				// Wait for handler to exit before calling cleanup
-				<-handleWaitCh
+				r.setExitState(<-handleWaitCh)
 				r.cleanup()
 
 				r.setState(structs.TaskStateDead, nil, false)
 				return
+
+			case <-r.pauseCh:
+				if !r.isPausePending() {
+					// The pause was cancelled by Resume
+					continue
+				}
+
+				r.runningLock.Lock()
+				running := r.running
+				r.runningLock.Unlock()
+				common := fmt.Sprintf("task %v for alloc %q", r.task.Name, r.alloc.ID)
+				if !running {
+					// The pause stays pending until the task is running
+					r.logger.Printf("[DEBUG] client: deferring pause of %v: task isn't running", common)
+					continue
+				}
+
+				r.logger.Printf("[DEBUG] client: pausing %s", common)
+				r.setPausePending(false)
+				r.killTask(nil)
+				close(stopCollection)
+
+				if handleWaitCh != nil {
+					<-handleWaitCh
+				}
+
+				// Clear the handle before persisting the pause so the
+				// stopped task is not recovered.
+				r.clearDriverHandle()
+				handleWaitCh = nil
+				stopCollection = nil
+
+				r.setPaused(true)
+				r.setPhase(TaskPhasePaused)
+				r.setState(structs.TaskStatePending, structs.NewTaskEvent(structs.TaskPaused), false)
+				paused = true
+				break WAIT
+
+			case <-r.resumeCh:
+				r.logger.Printf("[DEBUG] client: skipping resume of task %v for alloc %q: task isn't paused",
+					r.task.Name, r.alloc.ID)
+
+			case <-r.shutdownCh:
+				// Exit without killing the task so it may be recovered
+				r.logger.Printf("[DEBUG] client: shutting down task runner for task %q for alloc %q", r.task.Name, r.alloc.ID)
+				if stopCollection != nil {
+					close(stopCollection)
+				}
+				r.setExitResult(dstructs.NewWaitResult(-1, 0, ErrShutdown))
+				return
 			}
 		}
 
+		// Paused tasks are not restarted until they are resumed
+		if paused {
+			continue
+		}
+
 	RESTART:
 		// shouldRestart will block if the task should restart after a delay.
-		restart := r.shouldRestart()
+		restart, shutdown := r.shouldRestart()
+		if shutdown {
+			r.setExitResult(dstructs.NewWaitResult(-1, 0, ErrShutdown))
+			return
+		}
 		if !restart {
+			r.setPhase(TaskPhaseStopping)
 			r.cleanup()
 			r.setState(structs.TaskStateDead, nil, false)
 			return
 		}
 
 		// Clear the handle so a new driver will be created.
-		r.handleLock.Lock()
-		r.handle = nil
+		r.clearDriverHandle()
 		handleWaitCh = nil
 		stopCollection = nil
-		r.handleLock.Unlock()
+
+		// Pause instead of restarting if paused while waiting to restart
+		if r.isPausePending() {
+			r.logger.Printf("[DEBUG] client: pausing task %q for alloc %q instead of restarting it", r.task.Name, r.alloc.ID)
+			r.setPausePending(false)
+			r.setPaused(true)
+			r.setPhase(TaskPhasePaused)
+			r.setState(structs.TaskStatePending, structs.NewTaskEvent(structs.TaskPaused), false)
+		}
 	}
 }
 
 // cleanup removes Consul entries and calls Driver.Cleanup when a task is
 // stopping. Errors are logged.
 func (r *TaskRunner) cleanup() {
+	// The task will not be restarted so its local data can be removed
+	defer r.gcTaskDir()
+
 	// Remove from Consul
 	r.removeServices()
 
@@ -1311,12 +2159,38 @@ func (r *TaskRunner) cleanup() {
 	return
 }
 
+// gcTaskDir removes the contents of the task's local and secrets directories
+// if enabled by the client config. It must only be called once the task is
+// dead and will not be restarted.
+func (r *TaskRunner) gcTaskDir() {
+	if !r.config.GCTaskLocalDirs {
+		return
+	}
+
+	// Preserve the task dir if it may be migrated to a replacement alloc
+	tg := r.alloc.Job.LookupTaskGroup(r.alloc.TaskGroup)
+	if tg != nil && tg.EphemeralDisk != nil && (tg.EphemeralDisk.Sticky || tg.EphemeralDisk.Migrate) {
+		return
+	}
+
+	if err := r.taskDir.CleanLocal(); err != nil {
+		r.logger.Printf("[WARN] client: failed to remove local data of task %q in alloc %q: %v",
+			r.task.Name, r.alloc.ID, err)
+	}
+}
+
 // shouldRestart returns if the task should restart. If the return value is
 // true, the task's restart policy has already been considered and any wait time
-// between restarts has been applied.
-func (r *TaskRunner) shouldRestart() bool {
+// between restarts has been applied. shutdown is true if the task runner was
+// shutdown while waiting to restart, in which case the task's state is left
+// untouched.
+func (r *TaskRunner) shouldRestart() (restart, shutdown bool) {
 	state, when := r.restartTracker.GetState()
 	reason := r.restartTracker.GetReason()
+	r.restartReasonLock.Lock()
+	r.restartReason = reason
+	r.restartReasonLock.Unlock()
+
 	switch state {
 	case structs.TaskNotRestarting, structs.TaskTerminated:
 		r.logger.Printf("[INFO] client: Not restarting task: %v for alloc: %v ", r.task.Name, r.alloc.ID)
@@ -1326,7 +2200,7 @@ func (r *TaskRunner) shouldRestart() bool {
 					SetRestartReason(reason).SetFailsTask(),
 				false)
 		}
-		return false
+		return false, false
 	case structs.TaskRestarting:
 		r.logger.Printf("[INFO] client: Restarting task %q for alloc %q in %v", r.task.Name, r.alloc.ID, when)
 		r.setState(structs.TaskStatePending,
@@ -1336,17 +2210,33 @@ func (r *TaskRunner) shouldRestart() bool {
 			false)
 	default:
 		r.logger.Printf("[ERR] client: restart tracker returned unknown state: %q", state)
-		return false
+		return false, false
 	}
 
 	// Unregister from Consul while waiting to restart.
 	r.removeServices()
 
-	// Sleep but watch for destroy events.
-	select {
-	case <-time.After(when):
-	case <-r.destroyCh:
+	// Sleep but watch for destroy and shutdown events. Pausing the task ends
+	// the wait as the task isn't restarted until it is resumed.
+	r.setRestarting(true)
+	r.setPhase(TaskPhaseRestartWait)
+	delayCh := time.After(when)
+DELAY:
+	for {
+		select {
+		case <-delayCh:
+			break DELAY
+		case <-r.pauseCh:
+			if r.isPausePending() {
+				break DELAY
+			}
+		case <-r.destroyCh:
+			break DELAY
+		case <-r.shutdownCh:
+			break DELAY
+		}
 	}
+	r.setRestarting(false)
 
 	// Destroyed while we were waiting to restart, so abort.
 	r.destroyLock.Lock()
@@ -1355,10 +2245,19 @@ func (r *TaskRunner) shouldRestart() bool {
 	if destroyed {
 		r.logger.Printf("[DEBUG] client: Not restarting task: %v because it has been destroyed", r.task.Name)
 		r.setState(structs.TaskStateDead, r.destroyEvent, false)
-		return false
+		return false, false
 	}
 
-	return true
+	// Shutdown while we were waiting to restart, so exit leaving the task to
+	// be restarted once the task runner is restored.
+	select {
+	case <-r.shutdownCh:
+		r.logger.Printf("[DEBUG] client: Not restarting task: %v because the task runner has been shutdown", r.task.Name)
+		return false, true
+	default:
+	}
+
+	return true, false
 }
 
 // killTask kills the running task. A killing event can optionally be passed and
@@ -1372,8 +2271,15 @@ func (r *TaskRunner) killTask(killingEvent *structs.TaskEvent) {
 		return
 	}
 
-	// Get the kill timeout
+	// Get the kill timeout, compressing it if there is a drain deadline
 	timeout := driver.GetKillTimeout(r.task.KillTimeout, r.config.MaxKillTimeout)
+	compressed := false
+	if deadline := r.getKillDeadline(); !deadline.IsZero() {
+		if t := drainKillTimeout(timeout, deadline, time.Now()); t < timeout {
+			timeout = t
+			compressed = true
+		}
+	}
 
 	// Build the event
 	var event *structs.TaskEvent
@@ -1390,6 +2296,16 @@ func (r *TaskRunner) killTask(killingEvent *structs.TaskEvent) {
 
 	handle := r.getHandle()
 
+	// Update the handle so the driver uses the compressed kill timeout
+	if compressed {
+		task := r.task.Copy()
+		task.KillTimeout = timeout
+		if err := handle.Update(task); err != nil {
+			r.logger.Printf("[WARN] client: failed to update kill timeout of task %q for alloc %q: %v",
+				r.task.Name, r.alloc.ID, err)
+		}
+	}
+
 	// Kill the task using an exponential backoff in-case of failures.
 	destroySuccess, err := r.handleDestroy(handle)
 	if !destroySuccess {
@@ -1405,14 +2321,105 @@ func (r *TaskRunner) killTask(killingEvent *structs.TaskEvent) {
 	r.setState("", structs.NewTaskEvent(structs.TaskKilled).SetKillError(err), true)
 }
 
-// startTask creates the driver, task dir, and starts the task.
-func (r *TaskRunner) startTask() error {
-	// Create a driver
-	drv, err := r.createDriver()
-	if err != nil {
-		return fmt.Errorf("failed to create driver of task %q for alloc %q: %v",
-			r.task.Name, r.alloc.ID, err)
+// warnKillTimeout emits a driver message event if the task's kill timeout is
+// greater than the client's max_kill_timeout and will be capped when the task
+// is killed. Drivers don't advertise their own maximum; they all cap the kill
+// timeout using the client's. The warning is only emitted on the first start
+// of the task.
+func (r *TaskRunner) warnKillTimeout() {
+	if r.killTimeoutWarned {
+		return
+	}
+
+	timeout := driver.GetKillTimeout(r.task.KillTimeout, r.config.MaxKillTimeout)
+	if r.task.KillTimeout <= timeout {
+		return
+	}
+	r.killTimeoutWarned = true
+
+	msg := fmt.Sprintf("kill_timeout %v exceeds the client max_kill_timeout of %v and will be capped", r.task.KillTimeout, timeout)
+	r.logger.Printf("[WARN] client: task %q for alloc %q: %s", r.task.Name, r.alloc.ID, msg)
+	r.setState(structs.TaskStatePending, structs.NewTaskEvent(structs.TaskDriverMessage).SetDriverMessage(msg), false)
+}
+
+// watchdogTimeout returns how long the task may run without exiting before the
+// watchdog fires. Only tasks of batch jobs are watched as service and system
+// tasks are expected to run indefinitely. Zero is returned if the task isn't
+// watched.
+func (r *TaskRunner) watchdogTimeout() time.Duration {
+	if r.alloc.Job.Type != structs.JobTypeBatch {
+		return 0
+	}
+	return r.config.TaskWatchdogTimeout
+}
+
+// shutdownDelay returns how long to delay killing the task after its services
+// are deregistered. The task's shutdown delay is capped by the time remaining
+// before the drain deadline, if any.
+func (r *TaskRunner) shutdownDelay(now time.Time) time.Duration {
+	delay := r.task.ShutdownDelay
+	if deadline := r.getKillDeadline(); !deadline.IsZero() {
+		if remaining := deadline.Sub(now); remaining < delay {
+			delay = remaining
+		}
+	}
+	return delay
+}
+
+// drainKillTimeout returns the kill timeout to use for a task that must be
+// killed by the drain deadline. The graceful window is compressed to half of
+// the time remaining before the deadline and is zero once the deadline is
+// imminent.
+func drainKillTimeout(timeout time.Duration, deadline, now time.Time) time.Duration {
+	remaining := deadline.Sub(now)
+	if remaining <= drainKillImminent {
+		return 0
+	}
+	if half := remaining / 2; half < timeout {
+		return half
+	}
+	return timeout
+}
+
+// getKillDeadline returns the drain deadline set by KillWithDeadline or the
+// zero time if there is none.
+func (r *TaskRunner) getKillDeadline() time.Time {
+	r.destroyLock.Lock()
+	defer r.destroyLock.Unlock()
+	return r.killDeadline
+}
+
+// acquireStartSlot blocks until a slot is acquired from the node wide start
+// limiter, if any. An error is returned if the task runner is destroyed or
+// shutdown while waiting.
+func (r *TaskRunner) acquireStartSlot() error {
+	if r.config.StartLimiter == nil {
+		return nil
+	}
+
+	select {
+	case r.config.StartLimiter <- struct{}{}:
+		return nil
+	case <-r.destroyCh:
+		return fmt.Errorf("task %q for alloc %q destroyed while waiting to start", r.task.Name, r.alloc.ID)
+	case <-r.shutdownCh:
+		return fmt.Errorf("task %q for alloc %q shutdown while waiting to start", r.task.Name, r.alloc.ID)
 	}
+}
+
+// releaseStartSlot releases the slot acquired by acquireStartSlot.
+func (r *TaskRunner) releaseStartSlot() {
+	if r.config.StartLimiter != nil {
+		<-r.config.StartLimiter
+	}
+}
+
+// startTask prepares the task dir using the task's driver and starts the task.
+func (r *TaskRunner) startTask() error {
+	drv := r.driver
+
+	// Warn if the kill timeout will be capped before the task is started
+	r.warnKillTimeout()
 
 	// Run prestart
 	ctx := driver.NewExecContext(r.taskDir, r.envBuilder.Build())
@@ -1424,30 +2431,52 @@ func (r *TaskRunner) startTask() error {
 		r.createdResources.Merge(presp.CreatedResources)
 		r.createdResourcesLock.Unlock()
 
-		// Set any network configuration returned by the driver
+		// Set any network configuration and environment returned by the
+		// driver
 		r.envBuilder.SetDriverNetwork(presp.Network)
+		r.envBuilder.SetDriverEnv(presp.Env)
 	}
 
 	if err != nil {
 		wrapped := fmt.Sprintf("failed to initialize task %q for alloc %q: %v",
 			r.task.Name, r.alloc.ID, err)
 		r.logger.Printf("[WARN] client: error from prestart: %s", wrapped)
-		return structs.WrapRecoverable(wrapped, err)
+		return dstructs.WrapStartError(wrapped, err)
 	}
 
 	// Create a new context for Start since the environment may have been updated.
 	ctx = driver.NewExecContext(r.taskDir, r.envBuilder.Build())
 
+	// Wait for a slot from the node wide start limiter
+	if err := r.acquireStartSlot(); err != nil {
+		return err
+	}
+
 	// Start the job
 	sresp, err := drv.Start(ctx, r.task)
+	r.releaseStartSlot()
 	if err != nil {
 		wrapped := fmt.Sprintf("failed to start task %q for alloc %q: %v",
 			r.task.Name, r.alloc.ID, err)
 		r.logger.Printf("[WARN] client: %s", wrapped)
-		return structs.WrapRecoverable(wrapped, err)
+		return dstructs.WrapStartError(wrapped, err)
 
 	}
 
+	// Allow hooks to override the network returned by the driver
+	network, err := r.runNetworkHooks(sresp.Network)
+	if err != nil {
+		r.logger.Printf("[ERR] client: failed to update network for task %q alloc %q: %v", r.task.Name, r.alloc.ID, err)
+
+		// Kill the started task
+		if destroyed, err := r.handleDestroy(sresp.Handle); !destroyed {
+			r.logger.Printf("[ERR] client: failed to kill task %q alloc %q. Resources may be leaked: %v",
+				r.task.Name, r.alloc.ID, err)
+		}
+		return structs.NewRecoverableError(err, false)
+	}
+	sresp.Network = network
+
 	// Log driver network information
 	if sresp.Network != nil && sresp.Network.IP != "" {
 		if sresp.Network.AutoAdvertise {
@@ -1463,6 +2492,18 @@ func (r *TaskRunner) startTask() error {
 		r.logger.Printf("[TRACE] client: alloc %s task %s could not detect a driver IP", r.alloc.ID, r.task.Name)
 	}
 
+	if !r.setDriverHandleIfNil(sresp.Handle) {
+		// The task was recovered concurrently so kill the started task and
+		// keep running the recovered one
+		r.logger.Printf("[WARN] client: task %q for alloc %q was recovered while starting; killing the started task",
+			r.task.Name, r.alloc.ID)
+		if destroyed, err := r.handleDestroy(sresp.Handle); !destroyed {
+			r.logger.Printf("[ERR] client: failed to kill task %q alloc %q. Resources may be leaked: %v",
+				r.task.Name, r.alloc.ID, err)
+		}
+		return nil
+	}
+
 	// Update environment with the network defined by the driver's Start method.
 	r.envBuilder.SetDriverNetwork(sresp.Network)
 
@@ -1476,13 +2517,10 @@ func (r *TaskRunner) startTask() error {
 			r.logger.Printf("[ERR] client: failed to kill task %q alloc %q. Resources may be leaked: %v",
 				r.task.Name, r.alloc.ID, err)
 		}
+		r.clearDriverHandle()
 		return structs.NewRecoverableError(err, false)
 	}
 
-	r.handleLock.Lock()
-	r.handle = sresp.Handle
-	r.handleLock.Unlock()
-
 	// Need to persist the driver network between restarts
 	r.driverNetLock.Lock()
 	r.driverNet = sresp.Network
@@ -1580,6 +2618,9 @@ func (r *TaskRunner) collectResourceUsageStats(stopCollection <-chan struct{}) {
 	// collection interval
 	next := time.NewTimer(0)
 	defer next.Stop()
+
+	// lastPressureEvent rate limits the memory pressure events
+	var lastPressureEvent time.Time
 	for {
 		select {
 		case <-next.C:
@@ -1611,13 +2652,67 @@ func (r *TaskRunner) collectResourceUsageStats(stopCollection <-chan struct{}) {
 			r.resourceUsageLock.Unlock()
 			if ru != nil {
 				r.emitStats(ru)
+				if time.Since(lastPressureEvent) >= memoryPressureEventInterval && r.checkMemoryPressure(ru) {
+					lastPressureEvent = time.Now()
+				}
 			}
 		case <-stopCollection:
+			// Don't leave the gauges reporting the usage of a task that is
+			// no longer running
+			r.resourceUsageLock.RLock()
+			collected := r.resourceUsage != nil
+			r.resourceUsageLock.RUnlock()
+			if collected {
+				r.zeroStats()
+			}
 			return
 		}
 	}
 }
 
+// checkMemoryPressure emits a warning event if the task's memory usage is above
+// the configured fraction of its memory limit. It returns whether an event was
+// emitted.
+func (r *TaskRunner) checkMemoryPressure(ru *cstructs.TaskResourceUsage) bool {
+	threshold := r.config.TaskMemoryPressureThreshold
+	if threshold <= 0 || ru.ResourceUsage == nil || ru.ResourceUsage.MemoryStats == nil {
+		return false
+	}
+	if r.task.Resources == nil || r.task.Resources.MemoryMB <= 0 {
+		return false
+	}
+
+	limit := uint64(r.task.Resources.MemoryMB) * 1024 * 1024
+	usage := ru.ResourceUsage.MemoryStats.RSS
+	if float64(usage) < threshold*float64(limit) {
+		return false
+	}
+
+	msg := fmt.Sprintf("Task memory usage of %d MiB is above %.0f%% of its %d MiB limit",
+		usage/(1024*1024), threshold*100, r.task.Resources.MemoryMB)
+	r.logger.Printf("[WARN] client: task %q for alloc %q: %s", r.task.Name, r.alloc.ID, msg)
+	r.setState("", structs.NewTaskEvent(structs.TaskDriverMessage).
+		SetDriverMessage(msg).
+		SetSeverity(structs.TaskEventSeverityWarn), false)
+	return true
+}
+
+// zeroStats sets the task's resource usage gauges to zero.
+func (r *TaskRunner) zeroStats() {
+	if !r.config.PublishAllocationMetrics || r.config.AllocMetrics.Paused() {
+		return
+	}
+
+	ru := &cstructs.TaskResourceUsage{
+		ResourceUsage: &cstructs.ResourceUsage{
+			MemoryStats: &cstructs.MemoryStats{},
+			CpuStats:    &cstructs.CpuStats{},
+		},
+	}
+	r.setGaugeForMemory(ru)
+	r.setGaugeForCPU(ru)
+}
+
 // LatestResourceUsage returns the last resource utilization datapoint collected
 func (r *TaskRunner) LatestResourceUsage() *cstructs.TaskResourceUsage {
 	r.resourceUsageLock.RLock()
@@ -1657,6 +2752,9 @@ func (r *TaskRunner) handleUpdate(update *structs.Allocation) error {
 	// Merge in the task resources
 	updatedTask.Resources = update.TaskResources[updatedTask.Name]
 
+	// Changes that can't be applied to the running task require a restart
+	diff := diffTask(r.task, updatedTask)
+
 	// Interpolate the old task with the old env before updating the env as
 	// updating services in Consul need both the old and new interpolations
 	// to find differences.
@@ -1667,7 +2765,8 @@ func (r *TaskRunner) handleUpdate(update *structs.Allocation) error {
 
 	var mErr multierror.Error
 	r.handleLock.Lock()
-	if r.handle != nil {
+	running := r.handle != nil
+	if running {
 		drv, err := r.createDriver()
 		if err != nil {
 			// Something has really gone wrong; don't continue
@@ -1696,6 +2795,28 @@ func (r *TaskRunner) handleUpdate(update *structs.Allocation) error {
 	// Store the updated alloc.
 	r.alloc = update
 	r.task = updatedTask
+
+	// Download changed artifacts and render changed templates again the next
+	// time the task is started
+	if diff.changed("artifact") || diff.changed("template") {
+		r.persistLock.Lock()
+		if diff.changed("artifact") {
+			r.artifactsDownloaded = false
+		}
+		if diff.changed("template") {
+			r.templatesChanged = true
+		}
+		r.persistLock.Unlock()
+	}
+
+	// Restart the running task so the changes take effect. The restart is
+	// sent asynchronously as the run loop calls handleUpdate.
+	if running && diff.requiresRestart() {
+		reason := fmt.Sprintf("task updated: %s changed", strings.Join(diff.restart, ", "))
+		r.logger.Printf("[DEBUG] client: restarting task %q for alloc %q: %s", r.task.Name, r.alloc.ID, reason)
+		const noFailure = false
+		go r.Restart("update", reason, noFailure)
+	}
 	return mErr.ErrorOrNil()
 }
 
@@ -1738,12 +2859,17 @@ func (r *TaskRunner) removeServices() {
 func (r *TaskRunner) handleDestroy(handle driver.DriverHandle) (destroyed bool, err error) {
 	// Cap the number of times we attempt to kill the task.
 	for i := 0; i < killFailureLimit; i++ {
+		// Wait for the node wide kill limiter before each attempt
+		if l := r.config.KillLimiter; l != nil {
+			if lerr := l.Wait(context.Background()); lerr != nil {
+				r.logger.Printf("[WARN] client: failed waiting on kill limiter for task '%s' for alloc %q: %v",
+					r.task.Name, r.alloc.ID, lerr)
+			}
+		}
+
 		if err = handle.Kill(); err != nil {
 			// Calculate the new backoff
-			backoff := (1 << (2 * uint64(i))) * killBackoffBaseline
-			if backoff > killBackoffLimit {
-				backoff = killBackoffLimit
-			}
+			backoff := r.killBackoff(i)
 
 			r.logger.Printf("[ERR] client: failed to kill task '%s' for alloc %q. Retrying in %v: %v",
 				r.task.Name, r.alloc.ID, backoff, err)
@@ -1756,6 +2882,24 @@ func (r *TaskRunner) handleDestroy(handle driver.DriverHandle) (destroyed bool,
 	return
 }
 
+// killBackoff returns the backoff before the kill attempt following the given
+// failed attempt, using the client's kill backoff overrides if set.
+func (r *TaskRunner) killBackoff(attempt int) time.Duration {
+	baseline, limit := killBackoffBaseline, killBackoffLimit
+	if r.config.KillBackoffBaseline > 0 {
+		baseline = r.config.KillBackoffBaseline
+	}
+	if r.config.KillBackoffLimit > 0 {
+		limit = r.config.KillBackoffLimit
+	}
+
+	backoff := (1 << (2 * uint64(attempt))) * baseline
+	if backoff > limit {
+		backoff = limit
+	}
+	return backoff
+}
+
 // Restart will restart the task.
 func (r *TaskRunner) Restart(source, reason string, failure bool) {
 	reasonStr := fmt.Sprintf("%s: %s", source, reason)
@@ -1801,6 +2945,29 @@ func (r *TaskRunner) Kill(source, reason string, fail bool) {
 	r.Destroy(event)
 }
 
+// Fail kills the task and marks it as failed with the given reason, such as
+// when an operator fails the task after a manual intervention. The task is not
+// restarted and ends dead.
+func (r *TaskRunner) Fail(reason string) {
+	event := structs.NewTaskEvent(structs.TaskKilling).SetKillReason(reason).SetFailsTask()
+	r.logger.Printf("[DEBUG] client: failing task %v for alloc %q: %v", r.task.Name, r.alloc.ID, reason)
+	r.Destroy(event)
+}
+
+// KillWithDeadline kills the task like Kill but escalates to a hard kill
+// faster as the drain deadline approaches, shrinking the graceful window
+// rather than always waiting the task's kill_timeout. Drivers may enforce a
+// minimum kill timeout.
+func (r *TaskRunner) KillWithDeadline(source, reason string, fail bool, deadline time.Time) {
+	r.destroyLock.Lock()
+	if !r.destroy {
+		r.killDeadline = deadline
+	}
+	r.destroyLock.Unlock()
+
+	r.Kill(source, reason, fail)
+}
+
 func (r *TaskRunner) EmitEvent(source, message string) {
 	event := structs.NewTaskEvent(source).
 		SetMessage(message)
@@ -1809,6 +2976,15 @@ func (r *TaskRunner) EmitEvent(source, message string) {
 		source, r.task.Name, r.alloc.ID, message)
 }
 
+// resetUnblock blocks the starting of the task again until UnblockStart is
+// called. It must only be called by prestart.
+func (r *TaskRunner) resetUnblock() {
+	r.unblockLock.Lock()
+	defer r.unblockLock.Unlock()
+	r.unblocked = false
+	r.unblockCh = make(chan struct{})
+}
+
 // UnblockStart unblocks the starting of the task. It currently assumes only
 // consul-template will unblock
 func (r *TaskRunner) UnblockStart(source string) {
@@ -1825,10 +3001,14 @@ func (r *TaskRunner) UnblockStart(source string) {
 
 // Helper function for converting a WaitResult into a TaskTerminated event.
 func (r *TaskRunner) waitErrorToEvent(res *dstructs.WaitResult) *structs.TaskEvent {
-	return structs.NewTaskEvent(structs.TaskTerminated).
+	event := structs.NewTaskEvent(structs.TaskTerminated).
 		SetExitCode(res.ExitCode).
 		SetSignal(res.Signal).
 		SetExitMessage(res.Err)
+	if !res.Successful() {
+		event.SetSeverity(structs.TaskEventSeverityWarn)
+	}
+	return event
 }
 
 // Update is used to update the task of the context
@@ -1855,6 +3035,134 @@ func (r *TaskRunner) Destroy(event *structs.TaskEvent) {
 	close(r.destroyCh)
 }
 
+// Close kills the task and blocks until the task runner has exited and
+// released its resources, including emitting any buffered events to the
+// EventSink. It returns immediately if Run was never called and is safe to
+// call multiple times.
+func (r *TaskRunner) Close() {
+	r.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	r.ctxCancel()
+
+	if atomic.LoadInt32(&r.runStarted) == 0 {
+		return
+	}
+	<-r.waitCh
+	if r.eventSinkDoneCh != nil {
+		<-r.eventSinkDoneCh
+	}
+}
+
+// Shutdown is used to make the run loop exit without killing the task, such as
+// when the agent is shutting down. ExitResult returns a result wrapping
+// ErrShutdown once WaitCh is closed.
+func (r *TaskRunner) Shutdown() {
+	r.shutdownLock.Lock()
+	defer r.shutdownLock.Unlock()
+
+	if r.shutdown {
+		return
+	}
+	r.shutdown = true
+	close(r.shutdownCh)
+}
+
+// Pause stops the task without restarting it, keeping it scheduled until Resume
+// is called. The paused state is persisted so it survives agent restarts. If
+// the task isn't running the pause is applied once it is, or once the wait
+// before restarting it ends.
+func (r *TaskRunner) Pause() {
+	r.setPausePending(true)
+	r.notifyPause()
+}
+
+// notifyPause wakes up the run loop to apply a pending pause without blocking.
+func (r *TaskRunner) notifyPause() {
+	select {
+	case r.pauseCh <- struct{}{}:
+	default:
+		// A pause is already being notified
+	}
+}
+
+// Resume starts a paused task again. A pause that is still pending is
+// cancelled.
+func (r *TaskRunner) Resume() {
+	r.setPausePending(false)
+	select {
+	case r.resumeCh <- struct{}{}:
+	case <-r.waitCh:
+	}
+}
+
+// isPaused returns whether the task is paused.
+func (r *TaskRunner) isPaused() bool {
+	r.persistLock.Lock()
+	defer r.persistLock.Unlock()
+	return r.paused
+}
+
+// isPausePending returns whether a pause has yet to be applied.
+func (r *TaskRunner) isPausePending() bool {
+	r.persistLock.Lock()
+	defer r.persistLock.Unlock()
+	return r.pausePending
+}
+
+// setPausePending sets whether a pause has yet to be applied.
+func (r *TaskRunner) setPausePending(pending bool) {
+	r.persistLock.Lock()
+	r.pausePending = pending
+	r.persistLock.Unlock()
+}
+
+// setPaused sets whether the task is paused and persists it.
+func (r *TaskRunner) setPaused(paused bool) {
+	r.persistLock.Lock()
+	r.paused = paused
+	r.persistLock.Unlock()
+
+	if err := r.SaveState(); err != nil {
+		r.logger.Printf("[WARN] client: failed to save state of task %q for alloc %q: %v",
+			r.task.Name, r.alloc.ID, err)
+	}
+}
+
+// waitWhilePaused blocks while the task is paused. It returns whether the task
+// should be started, and if not, whether it was destroyed rather than the task
+// runner being shutdown.
+func (r *TaskRunner) waitWhilePaused() (resumed, destroyed bool) {
+	if !r.isPaused() {
+		return true, false
+	}
+
+	r.logger.Printf("[DEBUG] client: task %q for alloc %q is paused", r.task.Name, r.alloc.ID)
+	for {
+		select {
+		case <-r.resumeCh:
+			r.logger.Printf("[DEBUG] client: resuming task %q for alloc %q", r.task.Name, r.alloc.ID)
+			r.setPaused(false)
+			r.setState(structs.TaskStatePending, structs.NewTaskEvent(structs.TaskResumed), false)
+			return true, false
+		case <-r.pauseCh:
+			// Already paused
+			r.setPausePending(false)
+		case <-r.restartCh:
+			// Nothing to restart
+		case se := <-r.signalCh:
+			// Nothing to signal
+			se.result <- nil
+		case update := <-r.updateCh:
+			if err := r.handleUpdate(update); err != nil {
+				r.logger.Printf("[ERR] client: update to task %q failed: %v", r.task.Name, err)
+			}
+		case <-r.destroyCh:
+			return false, true
+		case <-r.shutdownCh:
+			return false, false
+		}
+	}
+}
+
 // getCreatedResources returns the resources created by drivers. It will never
 // return nil.
 func (r *TaskRunner) getCreatedResources() *driver.CreatedResources {
@@ -1936,7 +3244,7 @@ func (r *TaskRunner) setGaugeForCPU(ru *cstructs.TaskResourceUsage) {
 // emitStats emits resource usage stats of tasks to remote metrics collector
 // sinks
 func (r *TaskRunner) emitStats(ru *cstructs.TaskResourceUsage) {
-	if !r.config.PublishAllocationMetrics {
+	if !r.config.PublishAllocationMetrics || r.config.AllocMetrics.Paused() {
 		return
 	}
 
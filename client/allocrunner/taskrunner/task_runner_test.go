@@ -1,7 +1,11 @@
 package taskrunner
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
 	"fmt"
+	"hash"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -9,17 +13,22 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/boltdb/bolt"
 	"github.com/golang/snappy"
 	"github.com/hashicorp/nomad/client/allocdir"
 	"github.com/hashicorp/nomad/client/allocrunner/taskrunner/restarts"
 	"github.com/hashicorp/nomad/client/config"
 	consulApi "github.com/hashicorp/nomad/client/consul"
+	"github.com/hashicorp/nomad/client/driver"
 	"github.com/hashicorp/nomad/client/driver/env"
+	"github.com/hashicorp/nomad/client/state"
 	cstructs "github.com/hashicorp/nomad/client/structs"
 	"github.com/hashicorp/nomad/client/vaultclient"
 	"github.com/hashicorp/nomad/command/agent/consul"
@@ -28,6 +37,8 @@ import (
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/testutil"
 	"github.com/kr/pretty"
+	"github.com/ugorji/go/codec"
+	"golang.org/x/time/rate"
 )
 
 // Returns a tracker that never restarts.
@@ -40,9 +51,12 @@ type MockTaskStateUpdater struct {
 	state  string
 	failed bool
 	events []*structs.TaskEvent
+	mu     sync.Mutex
 }
 
 func (m *MockTaskStateUpdater) Update(name, state string, event *structs.TaskEvent, _ bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if state != "" {
 		m.state = state
 	}
@@ -136,7 +150,10 @@ func testTaskRunnerFromAlloc(t *testing.T, restarts bool, alloc *structs.Allocat
 	cclient := consul.NewMockAgent()
 	serviceClient := consul.NewServiceClient(cclient, logger, true)
 	go serviceClient.Run()
-	tr := NewTaskRunner(logger, conf, db, upd.Update, taskDir, alloc, task, vclient, serviceClient)
+	tr, err := NewTaskRunner(logger, conf, db, upd.Update, taskDir, alloc, task, vclient, serviceClient)
+	if err != nil {
+		t.Fatalf("error creating task runner: %v", err)
+	}
 	if !restarts {
 		tr.restartTracker = noRestartsTracker()
 	}
@@ -242,6 +259,71 @@ func TestTaskRunner_SimpleRun(t *testing.T) {
 
 }
 
+// TestTaskRunner_NewTaskRunner_Errors asserts NewTaskRunner returns typed
+// errors for each failure.
+func TestTaskRunner_NewTaskRunner_Errors(t *testing.T) {
+	t.Parallel()
+	logger := testlog.Logger(t)
+	conf := config.DefaultConfig()
+	conf.Node = mock.Node()
+	upd := &MockTaskStateUpdater{}
+
+	// Missing task group
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	alloc.TaskGroup = "missing"
+	tr, err := NewTaskRunner(logger, conf, nil, upd.Update, nil, alloc, task, nil, nil)
+	if tr != nil {
+		t.Fatalf("expected nil task runner; got %v", tr)
+	}
+	if err != ErrMissingTaskGroup {
+		t.Fatalf("expected ErrMissingTaskGroup; got %v", err)
+	}
+
+	// Unknown driver
+	alloc = mock.Alloc()
+	task = alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "unknown_driver"
+	tr, err = NewTaskRunner(logger, conf, nil, upd.Update, nil, alloc, task, nil, nil)
+	if tr != nil {
+		t.Fatalf("expected nil task runner; got %v", tr)
+	}
+	initErr, ok := err.(*DriverInitError)
+	if !ok {
+		t.Fatalf("expected *DriverInitError; got %T", err)
+	}
+	if initErr.Driver != "unknown_driver" || initErr.AllocID != alloc.ID {
+		t.Fatalf("bad driver init error: %#v", initErr)
+	}
+	if initErr.Err == nil {
+		t.Fatalf("expected underlying driver error: %#v", initErr)
+	}
+}
+
+// TestTaskRunner_DriverInfo asserts the driver name and capabilities reflect
+// the task's configured driver.
+func TestTaskRunner_DriverInfo(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	if name := ctx.tr.DriverName(); name != "mock_driver" {
+		t.Fatalf("expected driver name %q; got %q", "mock_driver", name)
+	}
+
+	expected := driver.DriverAbilities{
+		SendSignals: false,
+		Exec:        true,
+	}
+	if caps := ctx.tr.DriverCapabilities(); caps != expected {
+		t.Fatalf("expected driver capabilities %+v; got %+v", expected, caps)
+	}
+}
+
 func TestTaskRunner_Run_RecoverableStartError(t *testing.T) {
 	t.Parallel()
 	alloc := mock.Alloc()
@@ -285,6 +367,41 @@ func TestTaskRunner_Run_RecoverableStartError(t *testing.T) {
 	})
 }
 
+// TestTaskRunner_Run_AuthStartError asserts a task the driver failed to start
+// with an authentication error is failed without being restarted.
+func TestTaskRunner_Run_AuthStartError(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"start_error":      "unauthorized: authentication required",
+		"start_error_type": "auth",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	last := ctx.upd.events[len(ctx.upd.events)-1]
+	if last.Type != structs.TaskNotRestarting {
+		t.Fatalf("Last event was %v; want %v", last.Type, structs.TaskNotRestarting)
+	}
+	if last.RestartReason != restarts.ReasonAuthError {
+		t.Fatalf("Restart reason was %q; want %q", last.RestartReason, restarts.ReasonAuthError)
+	}
+	if !ctx.upd.failed {
+		t.Fatalf("expected the task to have failed")
+	}
+}
+
 func TestTaskRunner_Destroy(t *testing.T) {
 	t.Parallel()
 	alloc := mock.Alloc()
@@ -402,6 +519,55 @@ func TestTaskRunner_Update(t *testing.T) {
 	})
 }
 
+// TestTaskRunner_Update_Restart asserts an update the running task can't
+// apply restarts it while an in-place update doesn't.
+func TestTaskRunner_Update_Restart(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "100s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+
+	// Updating the services is applied in place
+	updateAlloc := alloc.Copy()
+	updateAlloc.Job.TaskGroups[0].Tasks[0].Services[0].Tags = []string{"new"}
+	ctx.tr.Update(updateAlloc)
+
+	// Updating the env requires a restart
+	updateAlloc = updateAlloc.Copy()
+	updateAlloc.Job.TaskGroups[0].Tasks[0].Env["FOO"] = "updated"
+	ctx.tr.Update(updateAlloc)
+
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 2)
+
+	ctx.upd.mu.Lock()
+	defer ctx.upd.mu.Unlock()
+	var restarts []string
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskRestartSignal {
+			restarts = append(restarts, e.RestartReason)
+		}
+	}
+	if len(restarts) != 1 {
+		t.Fatalf("expected 1 restart; got %v", restarts)
+	}
+	if !strings.Contains(restarts[0], "env changed") {
+		t.Fatalf("unexpected restart reason %q", restarts[0])
+	}
+	if ctx.upd.failed {
+		t.Fatalf("task should not have failed")
+	}
+}
+
 func TestTaskRunner_SaveRestoreState(t *testing.T) {
 	t.Parallel()
 	alloc := mock.Alloc()
@@ -440,8 +606,11 @@ func TestTaskRunner_SaveRestoreState(t *testing.T) {
 
 	// Create a new task runner
 	task2 := &structs.Task{Name: ctx.tr.task.Name, Driver: ctx.tr.task.Driver, Vault: ctx.tr.task.Vault}
-	tr2 := NewTaskRunner(ctx.tr.logger, ctx.tr.config, ctx.tr.stateDB, ctx.upd.Update,
+	tr2, err := NewTaskRunner(ctx.tr.logger, ctx.tr.config, ctx.tr.stateDB, ctx.upd.Update,
 		ctx.tr.taskDir, ctx.tr.alloc, task2, ctx.tr.vaultClient, ctx.tr.consul)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
 	tr2.restartTracker = noRestartsTracker()
 	if _, err := tr2.RestoreState(); err != nil {
 		t.Fatalf("err: %v", err)
@@ -761,24 +930,2523 @@ func TestTaskRunner_RestartTask(t *testing.T) {
 		t.Fatalf("Fifth Event was %v; want %v", ctx.upd.events[4].Type, structs.TaskKilling)
 	}
 
-	if ctx.upd.events[5].Type != structs.TaskKilled {
-		t.Fatalf("Sixth Event was %v; want %v", ctx.upd.events[5].Type, structs.TaskKilled)
+	if ctx.upd.events[5].Type != structs.TaskKilled {
+		t.Fatalf("Sixth Event was %v; want %v", ctx.upd.events[5].Type, structs.TaskKilled)
+	}
+
+	if ctx.upd.events[6].Type != structs.TaskRestarting {
+		t.Fatalf("Seventh Event was %v; want %v", ctx.upd.events[6].Type, structs.TaskRestarting)
+	}
+
+	if ctx.upd.events[7].Type != structs.TaskStarted {
+		t.Fatalf("Eighth Event was %v; want %v", ctx.upd.events[8].Type, structs.TaskStarted)
+	}
+	if ctx.upd.events[8].Type != structs.TaskKilling {
+		t.Fatalf("Ninth  Event was %v; want %v", ctx.upd.events[8].Type, structs.TaskKilling)
+	}
+
+	if ctx.upd.events[9].Type != structs.TaskKilled {
+		t.Fatalf("Tenth Event was %v; want %v", ctx.upd.events[9].Type, structs.TaskKilled)
+	}
+}
+
+// mockUpdateHook is a TaskUpdateHook fired by sending on its channel
+type mockUpdateHook struct {
+	ch chan string
+}
+
+func (h *mockUpdateHook) Name() string {
+	return "rotation"
+}
+
+func (h *mockUpdateHook) UpdateCh() <-chan string {
+	return h.ch
+}
+
+// TestTaskRunner_UpdateHook_Restart asserts that firing an update hook
+// restarts the task without counting against the restart policy.
+func TestTaskRunner_UpdateHook_Restart(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "0",
+		"run_for":   "100s",
+	}
+
+	// A failure restart would not be allowed
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	hook := &mockUpdateHook{ch: make(chan string, 1)}
+	ctx.tr.AddUpdateHook(hook)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskToStart(t, ctx)
+
+	// Fire the hook
+	hook.ch <- "secret changed"
+
+	// Wait for the task to be restarted and started again
+	testutil.WaitForResult(func() (bool, error) {
+		var restarted, signaled bool
+		for _, e := range ctx.upd.events {
+			switch e.Type {
+			case structs.TaskRestartSignal:
+				if e.RestartReason != "rotation: secret changed" {
+					return false, fmt.Errorf("unexpected restart reason: %q", e.RestartReason)
+				}
+				signaled = true
+			case structs.TaskRestarting:
+				restarted = true
+			case structs.TaskStarted:
+				if restarted {
+					return true, nil
+				}
+			case structs.TaskNotRestarting:
+				return false, fmt.Errorf("task not restarted: %v", ctx.upd)
+			}
+		}
+		return false, fmt.Errorf("task not restarted (signaled=%t restarted=%t): %v", signaled, restarted, ctx.upd)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	if ctx.upd.failed {
+		t.Fatalf("task should not be failed: %v", ctx.upd)
+	}
+}
+
+// killRecorder records the time of each kill attempt across handles
+type killRecorder struct {
+	l     sync.Mutex
+	kills []time.Time
+
+	// failures is the number of kills that fail before one succeeds
+	failures int
+}
+
+// killRecordingHandle is a driver handle whose kills are recorded
+type killRecordingHandle struct {
+	driver.DriverHandle
+	rec *killRecorder
+}
+
+func (h *killRecordingHandle) Kill() error {
+	h.rec.l.Lock()
+	defer h.rec.l.Unlock()
+	h.rec.kills = append(h.rec.kills, time.Now())
+	if len(h.rec.kills) <= h.rec.failures {
+		return fmt.Errorf("kill %d failed", len(h.rec.kills))
+	}
+	return nil
+}
+
+// TestTaskRunner_KillLimiter asserts that task runners sharing a kill limiter
+// have their kill attempts serialized by it.
+func TestTaskRunner_KillLimiter(t *testing.T) {
+	t.Parallel()
+	const interval = 200 * time.Millisecond
+	limiter := rate.NewLimiter(rate.Every(interval), 1)
+	rec := &killRecorder{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		ctx := testTaskRunnerFromAlloc(t, false, mock.Alloc())
+		defer ctx.Cleanup()
+		ctx.tr.config.KillLimiter = limiter
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle := &killRecordingHandle{rec: rec}
+			if destroyed, err := ctx.tr.handleDestroy(handle); !destroyed || err != nil {
+				t.Errorf("expected task to be destroyed; destroyed=%t err=%v", destroyed, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(rec.kills) != 2 {
+		t.Fatalf("expected 2 kill attempts; got %d", len(rec.kills))
+	}
+
+	// Allow for some clock slack
+	delta := rec.kills[1].Sub(rec.kills[0])
+	if delta < interval/2 {
+		t.Fatalf("expected kill attempts to be serialized; %v apart", delta)
+	}
+}
+
+// TestTaskRunner_ExitResult asserts the exit result is available once the wait
+// channel closes, both when the task exits and when the runner is shutdown.
+func TestTaskRunner_ExitResult(t *testing.T) {
+	t.Parallel()
+
+	t.Run("exited", func(t *testing.T) {
+		alloc := mock.Alloc()
+		task := alloc.Job.TaskGroups[0].Tasks[0]
+		task.Driver = "mock_driver"
+		task.Config = map[string]interface{}{
+			"exit_code": "0",
+			"run_for":   "10ms",
+		}
+
+		ctx := testTaskRunnerFromAlloc(t, false, alloc)
+		ctx.tr.MarkReceived()
+		go ctx.tr.Run()
+		defer ctx.Cleanup()
+
+		select {
+		case <-ctx.tr.WaitCh():
+		case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+			t.Fatalf("timeout")
+		}
+
+		res := ctx.tr.ExitResult()
+		if res == nil {
+			t.Fatalf("expected an exit result")
+		}
+		if !res.Successful() {
+			t.Fatalf("expected a successful exit result; got %v", res)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		alloc := mock.Alloc()
+		task := alloc.Job.TaskGroups[0].Tasks[0]
+		task.Driver = "mock_driver"
+		task.Config = map[string]interface{}{
+			"exit_code": "0",
+			"run_for":   "100s",
+		}
+
+		ctx := testTaskRunnerFromAlloc(t, false, alloc)
+		ctx.tr.MarkReceived()
+		go ctx.tr.Run()
+		defer ctx.Cleanup()
+
+		testWaitForTaskToStart(t, ctx)
+		ctx.tr.Shutdown()
+
+		select {
+		case <-ctx.tr.WaitCh():
+		case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+			t.Fatalf("timeout")
+		}
+
+		res := ctx.tr.ExitResult()
+		if res == nil {
+			t.Fatalf("expected an exit result")
+		}
+		if res.Err != ErrShutdown {
+			t.Fatalf("expected shutdown exit result; got %v", res)
+		}
+
+		// The task should have been left running
+		if ctx.upd.state != structs.TaskStateRunning {
+			t.Fatalf("expected task to be running; got %q", ctx.upd.state)
+		}
+		ctx.tr.getHandle().Kill()
+	})
+
+	t.Run("shutdown while restarting", func(t *testing.T) {
+		alloc := mock.Alloc()
+		alloc.Job.TaskGroups[0].RestartPolicy = &structs.RestartPolicy{
+			Attempts: 2,
+			Interval: 10 * time.Minute,
+			Delay:    10 * time.Minute,
+			Mode:     structs.RestartPolicyModeFail,
+		}
+		task := alloc.Job.TaskGroups[0].Tasks[0]
+		task.Driver = "mock_driver"
+		task.Config = map[string]interface{}{
+			"exit_code": "1",
+			"run_for":   "10ms",
+		}
+
+		ctx := testTaskRunnerFromAlloc(t, true, alloc)
+		ctx.tr.MarkReceived()
+		go ctx.tr.Run()
+		defer ctx.Cleanup()
+
+		testutil.WaitForResult(func() (bool, error) {
+			if phase := ctx.tr.Phase(); phase != TaskPhaseRestartWait {
+				return false, fmt.Errorf("got phase %q; want %q", phase, TaskPhaseRestartWait)
+			}
+			return true, nil
+		}, func(err error) {
+			t.Fatalf("err: %v", err)
+		})
+		ctx.tr.Shutdown()
+
+		select {
+		case <-ctx.tr.WaitCh():
+		case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+			t.Fatalf("timeout")
+		}
+
+		res := ctx.tr.ExitResult()
+		if res == nil || res.Err != ErrShutdown {
+			t.Fatalf("expected shutdown exit result; got %v", res)
+		}
+
+		// The task should not have been marked dead
+		ctx.upd.mu.Lock()
+		state := ctx.upd.state
+		ctx.upd.mu.Unlock()
+		if state == structs.TaskStateDead {
+			t.Fatalf("expected task to not be dead")
+		}
+	})
+}
+
+// TestTaskRunner_ExitState asserts the exit code and signal of the task are
+// readable once it exits.
+func TestTaskRunner_ExitState(t *testing.T) {
+	t.Parallel()
+
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code":   "3",
+		"exit_signal": "9",
+		"run_for":     "10ms",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+
+	if res := ctx.tr.ExitState(); res != nil {
+		t.Fatalf("expected no exit state before running; got %v", res)
+	}
+
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	res := ctx.tr.ExitState()
+	if res == nil {
+		t.Fatalf("expected an exit state")
+	}
+	if res.ExitCode != 3 || res.Signal != 9 {
+		t.Fatalf("expected exit code 3 and signal 9; got %v", res)
+	}
+
+	// The returned exit state is a copy
+	res.ExitCode = 0
+	if res := ctx.tr.ExitState(); res.ExitCode != 3 {
+		t.Fatalf("expected exit state to be unchanged; got %v", res)
+	}
+}
+
+// TestTaskRunner_RestartReason asserts the restart reason is updated on each
+// restart decision and matches the restart tracker.
+func TestTaskRunner_RestartReason(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	alloc.Job.TaskGroups[0].RestartPolicy = &structs.RestartPolicy{
+		Attempts: 1,
+		Interval: 10 * time.Minute,
+		Delay:    10 * time.Millisecond,
+		Mode:     structs.RestartPolicyModeFail,
+	}
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "1",
+		"run_for":   "10ms",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// The task should have been restarted once before failing
+	restarted := false
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskRestarting && e.RestartReason == restarts.ReasonWithinPolicy {
+			restarted = true
+		}
+	}
+	if !restarted {
+		t.Fatalf("expected task to be restarted: %v", ctx.upd.events)
+	}
+
+	reason := ctx.tr.RestartReason()
+	if reason != ctx.tr.restartTracker.GetReason() {
+		t.Fatalf("expected restart reason %q to match tracker %q", reason, ctx.tr.restartTracker.GetReason())
+	}
+	if !strings.Contains(reason, "Exceeded allowed attempts") {
+		t.Fatalf("unexpected restart reason: %q", reason)
+	}
+}
+
+// TestTaskRunner_SetDriverHandleIfNil asserts that only one of concurrent
+// recover and start attempts sets the driver handle.
+func TestTaskRunner_SetDriverHandleIfNil(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunnerFromAlloc(t, false, mock.Alloc())
+	defer ctx.Cleanup()
+
+	const attempts = 10
+	handles := make([]driver.DriverHandle, attempts)
+	won := make([]bool, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		handles[i] = &killRecordingHandle{rec: &killRecorder{}}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			won[i] = ctx.tr.setDriverHandleIfNil(handles[i])
+		}(i)
+	}
+	wg.Wait()
+
+	winners := 0
+	for i, w := range won {
+		if !w {
+			continue
+		}
+		winners++
+		if ctx.tr.getHandle() != handles[i] {
+			t.Fatalf("expected handle of winning attempt %d to be set", i)
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("expected exactly one attempt to set the handle; got %d", winners)
+	}
+}
+
+// recoveringNetworkHook sets the task's handle while the task is being started
+// to mimic the task being recovered concurrently.
+type recoveringNetworkHook struct {
+	tr     *TaskRunner
+	handle driver.DriverHandle
+}
+
+func (h *recoveringNetworkHook) Name() string {
+	return "recovering"
+}
+
+func (h *recoveringNetworkHook) UpdateNetwork(net *cstructs.DriverNetwork) (*cstructs.DriverNetwork, error) {
+	if !h.tr.setDriverHandleIfNil(h.handle) {
+		return nil, fmt.Errorf("task already has a handle")
+	}
+	return net, nil
+}
+
+// TestTaskRunner_StartTask_Recovered asserts that a task recovered while it is
+// being started keeps its recovered handle and the start succeeds.
+func TestTaskRunner_StartTask_Recovered(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	rec := &killRecorder{}
+	recovered := &killRecordingHandle{rec: rec}
+	ctx.tr.AddNetworkHook(&recoveringNetworkHook{tr: ctx.tr, handle: recovered})
+
+	if err := ctx.tr.startTask(); err != nil {
+		t.Fatalf("expected start to succeed: %v", err)
+	}
+	if ctx.tr.getHandle() != recovered {
+		t.Fatalf("expected the recovered handle to be kept")
+	}
+
+	rec.l.Lock()
+	kills := len(rec.kills)
+	rec.l.Unlock()
+	if kills != 0 {
+		t.Fatalf("expected the recovered task to not be killed; got %d kills", kills)
+	}
+}
+
+// TestTaskRunner_Running asserts Running reflects the task running and can be
+// read while the state is changed concurrently.
+func TestTaskRunner_Running(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunnerFromAlloc(t, false, mock.Alloc())
+	defer ctx.Cleanup()
+
+	if ctx.tr.Running() {
+		t.Fatalf("expected task to not be running")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(running bool) {
+			defer wg.Done()
+			ctx.tr.runningLock.Lock()
+			ctx.tr.running = running
+			ctx.tr.runningLock.Unlock()
+		}(i%2 == 0)
+		go func() {
+			defer wg.Done()
+			ctx.tr.Running()
+		}()
+	}
+	wg.Wait()
+
+	ctx.tr.runningLock.Lock()
+	ctx.tr.running = true
+	ctx.tr.runningLock.Unlock()
+	if !ctx.tr.Running() {
+		t.Fatalf("expected task to be running")
+	}
+}
+
+// prestartEnvDriverName is the name of a mock driver whose Prestart returns
+// environment variables
+const prestartEnvDriverName = "prestart_env_driver"
+
+// prestartEnvStarts records the environment each alloc was started with by
+// the prestartEnvDriver
+var prestartEnvStarts = struct {
+	sync.Mutex
+	env map[string]map[string]string
+}{env: make(map[string]map[string]string)}
+
+func init() {
+	driver.BuiltinDrivers[prestartEnvDriverName] = func(ctx *driver.DriverContext) driver.Driver {
+		return &prestartEnvDriver{Driver: driver.NewMockDriver(ctx)}
+	}
+}
+
+// prestartEnvDriver is a mock driver whose Prestart returns environment
+// variables and whose Start records the environment it is given.
+type prestartEnvDriver struct {
+	driver.Driver
+}
+
+func (d *prestartEnvDriver) Prestart(*driver.ExecContext, *structs.Task) (*driver.PrestartResponse, error) {
+	resp := driver.NewPrestartResponse()
+	resp.Env = map[string]string{"PRESTART_ENV": "from-prestart"}
+	return resp, nil
+}
+
+func (d *prestartEnvDriver) Start(ctx *driver.ExecContext, task *structs.Task) (*driver.StartResponse, error) {
+	envMap := ctx.TaskEnv.Map()
+	prestartEnvStarts.Lock()
+	prestartEnvStarts.env[envMap[env.AllocID]] = envMap
+	prestartEnvStarts.Unlock()
+	return d.Driver.Start(ctx, task)
+}
+
+// TestTaskRunner_PrestartEnv asserts the environment returned by the driver's
+// Prestart is used to Start the task.
+func TestTaskRunner_PrestartEnv(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = prestartEnvDriverName
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskToStart(t, ctx)
+
+	prestartEnvStarts.Lock()
+	envMap, ok := prestartEnvStarts.env[alloc.ID]
+	prestartEnvStarts.Unlock()
+	if !ok {
+		t.Fatalf("task was not started by the driver")
+	}
+	if v := envMap["PRESTART_ENV"]; v != "from-prestart" {
+		t.Fatalf("expected PRESTART_ENV=%q in start env; got %q", "from-prestart", v)
+	}
+}
+
+// testWaitForTaskEvent waits for the task to have emitted count events of the
+// given type.
+func testWaitForTaskEvent(t *testing.T, ctx *taskRunnerTestCtx, eventType string, count int) {
+	testutil.WaitForResult(func() (bool, error) {
+		n := 0
+		for _, e := range ctx.upd.events {
+			if e.Type == eventType {
+				n++
+			}
+		}
+		if n < count {
+			return false, fmt.Errorf("expected %d %q events; got %d: %v", count, eventType, n, ctx.upd)
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+}
+
+// TestTaskRunner_PauseResume asserts a paused task is stopped without being
+// restarted until it is resumed.
+func TestTaskRunner_PauseResume(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "0",
+		"run_for":   "100s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskToStart(t, ctx)
+
+	// Pause the task
+	ctx.tr.Pause()
+	testWaitForTaskEvent(t, ctx, structs.TaskPaused, 1)
+	if ctx.tr.Running() {
+		t.Fatalf("expected paused task to not be running")
+	}
+	if !ctx.tr.isPaused() {
+		t.Fatalf("expected task to be paused")
+	}
+	if ctx.upd.state != structs.TaskStatePending {
+		t.Fatalf("expected paused task to be pending; got %q", ctx.upd.state)
+	}
+
+	// The task should not be restarted while paused
+	time.Sleep(100 * time.Millisecond)
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskRestarting || e.Type == structs.TaskNotRestarting {
+			t.Fatalf("paused task should not be restarted: %v", ctx.upd)
+		}
+	}
+
+	// Resume the task
+	ctx.tr.Resume()
+	testWaitForTaskEvent(t, ctx, structs.TaskResumed, 1)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 2)
+	if ctx.tr.isPaused() {
+		t.Fatalf("expected task to be resumed")
+	}
+	if ctx.upd.failed {
+		t.Fatalf("task should not be failed: %v", ctx.upd)
+	}
+
+	ctx.tr.Kill("test", "kill", false)
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// TestTaskRunner_Pause_Prestart asserts pausing a task during a blocking
+// prestart doesn't block and pauses the task once it is running.
+func TestTaskRunner_Pause_Prestart(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "100s",
+	}
+	task.Templates = []*structs.Template{
+		{
+			EmbeddedTmpl: "{{key \"foo\"}}",
+			DestPath:     "local/test",
+			ChangeMode:   structs.TemplateChangeModeNoop,
+		},
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	// Pause while the template blocks prestart
+	testWaitForTaskEvent(t, ctx, structs.TaskSetup, 1)
+	pausedCh := make(chan struct{})
+	go func() {
+		ctx.tr.Pause()
+		close(pausedCh)
+	}()
+	select {
+	case <-pausedCh:
+	case <-time.After(time.Duration(testutil.TestMultiplier()*5) * time.Second):
+		t.Fatalf("timeout waiting for Pause to return")
+	}
+
+	// The pause is applied once the task is running
+	ctx.tr.UnblockStart("test")
+	testWaitForTaskEvent(t, ctx, structs.TaskPaused, 1)
+	if ctx.tr.Running() {
+		t.Fatalf("expected paused task to not be running")
+	}
+	if !ctx.tr.isPaused() {
+		t.Fatalf("expected task to be paused")
+	}
+
+	ctx.tr.Resume()
+	testWaitForTaskEvent(t, ctx, structs.TaskResumed, 1)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 2)
+}
+
+// TestTaskRunner_Pause_RestartWait asserts pausing a task while it waits to
+// be restarted doesn't block and pauses the task instead of restarting it.
+func TestTaskRunner_Pause_RestartWait(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	*alloc.Job.TaskGroups[0].RestartPolicy = structs.RestartPolicy{
+		Attempts: 3,
+		Interval: 10 * time.Minute,
+		Delay:    time.Hour,
+		Mode:     structs.RestartPolicyModeFail,
+	}
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "1",
+		"run_for":   "10ms",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskEvent(t, ctx, structs.TaskRestarting, 1)
+	pausedCh := make(chan struct{})
+	go func() {
+		ctx.tr.Pause()
+		close(pausedCh)
+	}()
+	select {
+	case <-pausedCh:
+	case <-time.After(time.Duration(testutil.TestMultiplier()*5) * time.Second):
+		t.Fatalf("timeout waiting for Pause to return")
+	}
+
+	testWaitForTaskEvent(t, ctx, structs.TaskPaused, 1)
+	if !ctx.tr.isPaused() {
+		t.Fatalf("expected task to be paused")
+	}
+
+	ctx.upd.mu.Lock()
+	defer ctx.upd.mu.Unlock()
+	started := 0
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskStarted {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Fatalf("expected the paused task to not be restarted; started %d times", started)
+	}
+}
+
+// TestTaskRunner_Pause_Persists asserts a paused task remains paused when its
+// state is restored.
+func TestTaskRunner_Pause_Persists(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "0",
+		"run_for":   "100s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskToStart(t, ctx)
+	ctx.tr.Pause()
+	testWaitForTaskEvent(t, ctx, structs.TaskPaused, 1)
+
+	// Stop the first runner without destroying its state
+	ctx.tr.Shutdown()
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// Create a new task runner from the persisted state
+	upd2 := &MockTaskStateUpdater{}
+	task2 := &structs.Task{Name: ctx.tr.task.Name, Driver: ctx.tr.task.Driver, Config: task.Config}
+	tr2, err := NewTaskRunner(ctx.tr.logger, ctx.tr.config, ctx.tr.stateDB, upd2.Update,
+		ctx.tr.taskDir, ctx.tr.alloc, task2, ctx.tr.vaultClient, ctx.tr.consul)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tr2.restartTracker = noRestartsTracker()
+	if _, err := tr2.RestoreState(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !tr2.isPaused() {
+		t.Fatalf("expected restored task to be paused")
+	}
+	go tr2.Run()
+
+	// The restored task should not be started until it is resumed
+	time.Sleep(100 * time.Millisecond)
+	if tr2.Running() {
+		t.Fatalf("expected restored paused task to not be running")
+	}
+
+	tr2.Resume()
+	testutil.WaitForResult(func() (bool, error) {
+		return tr2.Running(), fmt.Errorf("resumed task not running: %v", upd2)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	tr2.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-tr2.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestTaskRunner_MockTaskRunner(t *testing.T) {
+	t.Parallel()
+	tr, cleanup := MockTaskRunner(t)
+	defer cleanup()
+
+	go tr.Run()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	select {
+	case <-tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if res := tr.ExitResult(); res == nil || !res.Successful() {
+		t.Fatalf("expected successful exit; got %v", res)
+	}
+}
+
+// TestTaskRunner_KillTimeout_ClientMax asserts a warning is emitted when the
+// task's kill timeout exceeds the client max_kill_timeout and that the capped
+// timeout is used when killing the task.
+func TestTaskRunner_KillTimeout_ClientMax(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.KillTimeout = 30 * time.Second
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.config.MaxKillTimeout = 2 * time.Second
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+
+	var warning *structs.TaskEvent
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskDriverMessage {
+			warning = e
+			break
+		}
+	}
+	if warning == nil {
+		t.Fatalf("expected kill timeout warning: %v", ctx.upd)
+	}
+	if !strings.Contains(warning.DriverMessage, "client max_kill_timeout") {
+		t.Fatalf("unexpected driver message: %q", warning.DriverMessage)
+	}
+
+	// The warning should not be repeated when the task is restarted
+	ctx.tr.Restart("test", "restart", false)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 2)
+
+	ctx.upd.mu.Lock()
+	warnings := 0
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskDriverMessage && strings.Contains(e.DriverMessage, "kill_timeout") {
+			warnings++
+		}
+	}
+	ctx.upd.mu.Unlock()
+	if warnings != 1 {
+		t.Fatalf("expected 1 kill timeout warning; got %d", warnings)
+	}
+
+	ctx.tr.Kill("test", "kill", false)
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	var killing *structs.TaskEvent
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskKilling {
+			killing = e
+		}
+	}
+	if killing == nil {
+		t.Fatalf("expected killing event: %v", ctx.upd)
+	}
+	if killing.KillTimeout != 2*time.Second {
+		t.Fatalf("expected kill timeout of %v; got %v", 2*time.Second, killing.KillTimeout)
+	}
+}
+
+func TestTaskRunner_DrainKillTimeout(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	timeout := 10 * time.Second
+
+	cases := []struct {
+		remaining time.Duration
+		expected  time.Duration
+	}{
+		{time.Minute, timeout},
+		{20 * time.Second, timeout},
+		{10 * time.Second, 5 * time.Second},
+		{4 * time.Second, 2 * time.Second},
+		{drainKillImminent, 0},
+		{-time.Second, 0},
+	}
+
+	last := timeout
+	for _, c := range cases {
+		actual := drainKillTimeout(timeout, now.Add(c.remaining), now)
+		if actual != c.expected {
+			t.Fatalf("remaining %v: expected %v; got %v", c.remaining, c.expected, actual)
+		}
+		if actual > last {
+			t.Fatalf("remaining %v: graceful window grew from %v to %v", c.remaining, last, actual)
+		}
+		last = actual
+	}
+}
+
+// TestTaskRunner_KillWithDeadline asserts the graceful window is compressed
+// when a task is killed with a drain deadline.
+func TestTaskRunner_KillWithDeadline(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.KillTimeout = 10 * time.Second
+	task.Config = map[string]interface{}{
+		"run_for":    "60s",
+		"kill_after": "20s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.config.MaxKillTimeout = 30 * time.Second
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+
+	start := time.Now()
+	ctx.tr.KillWithDeadline("test", "drain", false, start.Add(4*time.Second))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed >= task.KillTimeout {
+		t.Fatalf("expected task to be killed before its kill timeout; took %v", elapsed)
+	}
+
+	var killing *structs.TaskEvent
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskKilling {
+			killing = e
+		}
+	}
+	if killing == nil {
+		t.Fatalf("expected killing event: %v", ctx.upd)
+	}
+	if killing.KillTimeout <= 0 || killing.KillTimeout > 2*time.Second {
+		t.Fatalf("expected compressed kill timeout of at most 2s; got %v", killing.KillTimeout)
+	}
+}
+
+// TestTaskRunner_ShutdownDelay_Deadline asserts the shutdown delay is capped by
+// the drain deadline.
+func TestTaskRunner_ShutdownDelay_Deadline(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.ShutdownDelay = 10 * time.Second
+	task.Config = map[string]interface{}{
+		"run_for": "60s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	now := time.Now()
+	if delay := ctx.tr.shutdownDelay(now); delay != task.ShutdownDelay {
+		t.Fatalf("expected delay of %v without a deadline; got %v", task.ShutdownDelay, delay)
+	}
+
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+
+	start := time.Now()
+	ctx.tr.KillWithDeadline("test", "drain", false, start.Add(1*time.Second))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed >= task.ShutdownDelay {
+		t.Fatalf("expected shutdown delay to be capped by the deadline; took %v", elapsed)
+	}
+	if delay := ctx.tr.shutdownDelay(start); delay > 1*time.Second {
+		t.Fatalf("expected delay of at most 1s; got %v", delay)
+	}
+}
+
+// TestTaskRunner_LocalStateSnapshot asserts local state snapshots can be read
+// while state is persisted and are copies. Run with -race.
+func TestTaskRunner_LocalStateSnapshot(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunner(t, false)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for i := 0; i < 50; i++ {
+			if err := ctx.tr.SaveState(); err != nil {
+				t.Errorf("error saving state: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		snap := ctx.tr.LocalStateSnapshot()
+		if snap.HandleID == "" {
+			t.Fatalf("expected handle id in snapshot: %#v", snap)
+		}
+
+		// Mutating the snapshot must not affect the task runner
+		snap.HandleID = "mutated"
+		snap.CreatedResources.Add("mutated", "mutated")
+	}
+	<-doneCh
+
+	snap := ctx.tr.LocalStateSnapshot()
+	if snap.HandleID == "mutated" {
+		t.Fatalf("snapshot shares handle id with task runner")
+	}
+	if _, ok := snap.CreatedResources.Resources["mutated"]; ok {
+		t.Fatalf("snapshot shares created resources with task runner")
+	}
+
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+type mockEventSink struct {
+	events []*structs.TaskEvent
+	mu     sync.Mutex
+}
+
+func (m *mockEventSink) EmitTaskEvent(allocID, taskName string, event *structs.TaskEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+}
+
+func (m *mockEventSink) Events() []*structs.TaskEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*structs.TaskEvent(nil), m.events...)
+}
+
+// TestTaskRunner_EventSink asserts every task event reaches the event sink in
+// order.
+func TestTaskRunner_EventSink(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunner(t, false)
+	sink := &mockEventSink{}
+	ctx.tr.config.EventSink = sink
+	ctx.tr.eventSinkCh = make(chan *structs.TaskEvent, eventSinkBufferSize)
+	ctx.tr.eventSinkDoneCh = make(chan struct{})
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	expected := ctx.upd.events
+	testutil.WaitForResult(func() (bool, error) {
+		if n := len(sink.Events()); n != len(expected) {
+			return false, fmt.Errorf("expected %d events; got %d", len(expected), n)
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	for i, e := range sink.Events() {
+		if e.Type != expected[i].Type || e.Time != expected[i].Time {
+			t.Fatalf("event %d: expected %q; got %q", i, expected[i].Type, e.Type)
+		}
+	}
+	if n := ctx.tr.DroppedSinkEvents(); n != 0 {
+		t.Fatalf("expected no dropped events; got %d", n)
+	}
+}
+
+// TestTaskRunner_EventSink_Overflow asserts events are dropped and counted
+// when the event sink buffer is full.
+func TestTaskRunner_EventSink_Overflow(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunner(t, false)
+	defer ctx.Cleanup()
+	ctx.tr.config.EventSink = &mockEventSink{}
+	ctx.tr.eventSinkCh = make(chan *structs.TaskEvent, eventSinkBufferSize)
+	ctx.tr.eventSinkDoneCh = make(chan struct{})
+
+	// Without Run nothing consumes the buffered events
+	for i := 0; i < eventSinkBufferSize+5; i++ {
+		ctx.tr.EmitEvent("test", fmt.Sprintf("event %d", i))
+	}
+
+	if n := ctx.tr.DroppedSinkEvents(); n != 5 {
+		t.Fatalf("expected 5 dropped events; got %d", n)
+	}
+	if n := len(ctx.tr.eventSinkCh); n != eventSinkBufferSize {
+		t.Fatalf("expected %d buffered events; got %d", eventSinkBufferSize, n)
+	}
+}
+
+// TestTaskRunner_EventSink_Closed asserts events emitted after the remaining
+// events have been delivered are dropped and counted.
+func TestTaskRunner_EventSink_Closed(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunner(t, false)
+	sink := &mockEventSink{}
+	ctx.tr.config.EventSink = sink
+	ctx.tr.eventSinkCh = make(chan *structs.TaskEvent, eventSinkBufferSize)
+	ctx.tr.eventSinkDoneCh = make(chan struct{})
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+	<-ctx.tr.eventSinkDoneCh
+	emitted := len(sink.Events())
+
+	ctx.tr.EmitEvent("test", "after exit")
+
+	if n := ctx.tr.DroppedSinkEvents(); n != 1 {
+		t.Fatalf("expected 1 dropped event; got %d", n)
+	}
+	if n := len(sink.Events()); n != emitted {
+		t.Fatalf("expected %d events emitted; got %d", emitted, n)
+	}
+}
+
+// slowEventSink is an event sink that takes a while to emit each event
+type slowEventSink struct {
+	mockEventSink
+	delay time.Duration
+}
+
+func (m *slowEventSink) EmitTaskEvent(allocID, taskName string, event *structs.TaskEvent) {
+	time.Sleep(m.delay)
+	m.mockEventSink.EmitTaskEvent(allocID, taskName, event)
+}
+
+// TestTaskRunner_EventSink_Slow asserts events are dropped when a slow event
+// sink falls behind, unless the task runner is configured to block.
+func TestTaskRunner_EventSink_Slow(t *testing.T) {
+	t.Parallel()
+	for _, block := range []bool{false, true} {
+		block := block
+		t.Run(fmt.Sprintf("block=%t", block), func(t *testing.T) {
+			t.Parallel()
+			alloc := mock.Alloc()
+			task := alloc.Job.TaskGroups[0].Tasks[0]
+			task.Driver = "mock_driver"
+			task.Config = map[string]interface{}{
+				"run_for": "10ms",
+			}
+
+			ctx := testTaskRunnerFromAlloc(t, false, alloc)
+			defer ctx.Cleanup()
+			sink := &slowEventSink{delay: 200 * time.Millisecond}
+			ctx.tr.config.EventSink = sink
+			ctx.tr.config.EventSinkBlock = block
+			ctx.tr.eventSinkCh = make(chan *structs.TaskEvent, 1)
+			ctx.tr.eventSinkDoneCh = make(chan struct{})
+			ctx.tr.MarkReceived()
+			go ctx.tr.Run()
+
+			select {
+			case <-ctx.tr.WaitCh():
+			case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+				t.Fatalf("timeout")
+			}
+			<-ctx.tr.eventSinkDoneCh
+
+			dropped := ctx.tr.DroppedSinkEvents()
+			emitted := len(sink.Events())
+			if block {
+				if dropped != 0 {
+					t.Fatalf("expected no dropped events; got %d", dropped)
+				}
+				if expected := len(ctx.upd.events); emitted != expected {
+					t.Fatalf("expected %d events emitted; got %d", expected, emitted)
+				}
+			} else {
+				if dropped == 0 {
+					t.Fatalf("expected dropped events")
+				}
+				if expected := len(ctx.upd.events); emitted+int(dropped) != expected {
+					t.Fatalf("expected %d events emitted or dropped; got %d emitted and %d dropped",
+						expected, emitted, dropped)
+				}
+			}
+		})
+	}
+}
+
+// TestTaskRunner_Fail asserts failing a task kills it without restarting and
+// leaves it dead and failed with the given reason.
+func TestTaskRunner_Fail(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskToStart(t, ctx)
+
+	ctx.tr.Fail("manual intervention")
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if ctx.upd.state != structs.TaskStateDead {
+		t.Fatalf("expected dead state; got %v", ctx.upd.state)
+	}
+	if !ctx.upd.failed {
+		t.Fatalf("expected task to be failed: %v", ctx.upd)
+	}
+
+	var killing *structs.TaskEvent
+	for _, e := range ctx.upd.events {
+		switch e.Type {
+		case structs.TaskKilling:
+			killing = e
+		case structs.TaskRestarting:
+			t.Fatalf("unexpected restart: %v", ctx.upd)
+		}
+	}
+	if killing == nil || !killing.FailsTask {
+		t.Fatalf("expected failing killing event: %v", ctx.upd)
+	}
+	if killing.KillReason != "manual intervention" {
+		t.Fatalf("expected kill reason %q; got %q", "manual intervention", killing.KillReason)
+	}
+}
+
+// TestTaskRunner_Context asserts goroutines tied to the task runner's context
+// exit when the task stops or the task runner is shutdown.
+func TestTaskRunner_Context(t *testing.T) {
+	t.Parallel()
+
+	stop := map[string]func(tr *TaskRunner){
+		"killed": func(tr *TaskRunner) {
+			tr.Kill("test", "kill", false)
+		},
+		"shutdown": func(tr *TaskRunner) {
+			tr.Shutdown()
+		},
+	}
+
+	for name, stopFn := range stop {
+		stopFn := stopFn
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			alloc := mock.Alloc()
+			task := alloc.Job.TaskGroups[0].Tasks[0]
+			task.Driver = "mock_driver"
+			task.Config = map[string]interface{}{
+				"run_for": "10s",
+			}
+
+			ctx := testTaskRunnerFromAlloc(t, false, alloc)
+			ctx.tr.MarkReceived()
+			defer ctx.Cleanup()
+
+			// Spawn a goroutine as a hook would
+			exitCh := make(chan struct{})
+			go func() {
+				defer close(exitCh)
+				<-ctx.tr.Context().Done()
+			}()
+
+			go ctx.tr.Run()
+			testWaitForTaskToStart(t, ctx)
+
+			select {
+			case <-exitCh:
+				t.Fatalf("goroutine exited before the task stopped")
+			default:
+			}
+
+			stopFn(ctx.tr)
+			select {
+			case <-exitCh:
+			case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+				t.Fatalf("timeout waiting for goroutine to exit")
+			}
+			<-ctx.tr.WaitCh()
+
+			// Shutdown leaves the task running so kill it directly
+			if h := ctx.tr.getHandle(); h != nil {
+				h.Kill()
+			}
+		})
+	}
+}
+
+// TestTaskRunner_KillBackoff asserts the kill backoff schedule respects the
+// client's overrides.
+func TestTaskRunner_KillBackoff(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunner(t, false)
+	defer ctx.Cleanup()
+
+	// Defaults
+	expected := []time.Duration{5 * time.Second, 20 * time.Second, 80 * time.Second, 2 * time.Minute}
+	for i, e := range expected {
+		if b := ctx.tr.killBackoff(i); b != e {
+			t.Fatalf("default attempt %d: expected %v; got %v", i, e, b)
+		}
+	}
+
+	// Overrides
+	ctx.tr.config.KillBackoffBaseline = 10 * time.Millisecond
+	ctx.tr.config.KillBackoffLimit = 100 * time.Millisecond
+	expected = []time.Duration{10 * time.Millisecond, 40 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}
+	for i, e := range expected {
+		if b := ctx.tr.killBackoff(i); b != e {
+			t.Fatalf("override attempt %d: expected %v; got %v", i, e, b)
+		}
+	}
+
+	// handleDestroy waits the overridden backoff between failed kills
+	rec := &killRecorder{failures: 2}
+	destroyed, err := ctx.tr.handleDestroy(&killRecordingHandle{rec: rec})
+	if !destroyed || err != nil {
+		t.Fatalf("expected task to be destroyed; got %v %v", destroyed, err)
+	}
+	if len(rec.kills) != 3 {
+		t.Fatalf("expected 3 kill attempts; got %d", len(rec.kills))
+	}
+	for i := 1; i < len(rec.kills); i++ {
+		if d := rec.kills[i].Sub(rec.kills[i-1]); d < expected[i-1] || d > expected[i-1]+time.Second {
+			t.Fatalf("attempt %d: expected backoff of %v; got %v", i, expected[i-1], d)
+		}
+	}
+}
+
+func TestTaskRunner_AllocIDTaskName(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	if id := ctx.tr.AllocID(); id != alloc.ID {
+		t.Fatalf("expected alloc id %q; got %q", alloc.ID, id)
+	}
+	if name := ctx.tr.TaskName(); name != alloc.Job.TaskGroups[0].Tasks[0].Name {
+		t.Fatalf("expected task name %q; got %q", alloc.Job.TaskGroups[0].Tasks[0].Name, name)
+	}
+}
+
+// TestTaskRunner_Liveness asserts running tasks periodically emit liveness
+// events when enabled.
+func TestTaskRunner_Liveness(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.config.TaskLivenessInterval = 50 * time.Millisecond
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskToStart(t, ctx)
+	time.Sleep(300 * time.Millisecond)
+
+	ctx.tr.Kill("test", "kill", false)
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	n := 0
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskDriverMessage && e.DriverMessage == TaskLivenessMessage {
+			n++
+		}
+	}
+	if n < 3 {
+		t.Fatalf("expected at least 3 liveness events; got %d: %v", n, ctx.upd)
+	}
+}
+
+// TestTaskRunner_StableUptime asserts the restart attempts of a task are reset
+// when it runs past the stable uptime before exiting.
+func TestTaskRunner_StableUptime(t *testing.T) {
+	t.Parallel()
+	for _, stable := range []bool{false, true} {
+		stable := stable
+		t.Run(fmt.Sprintf("stable=%t", stable), func(t *testing.T) {
+			t.Parallel()
+			alloc := mock.Alloc()
+			*alloc.Job.TaskGroups[0].RestartPolicy = structs.RestartPolicy{
+				Attempts: 1,
+				Interval: 10 * time.Minute,
+				Delay:    10 * time.Millisecond,
+				Mode:     structs.RestartPolicyModeFail,
+			}
+			task := alloc.Job.TaskGroups[0].Tasks[0]
+			task.Driver = "mock_driver"
+			task.Config = map[string]interface{}{
+				"exit_code": "1",
+				"run_for":   "100ms",
+			}
+
+			ctx := testTaskRunnerFromAlloc(t, true, alloc)
+			if stable {
+				ctx.tr.config.TaskStableUptime = 50 * time.Millisecond
+			}
+			ctx.tr.MarkReceived()
+			go ctx.tr.Run()
+			defer ctx.Cleanup()
+
+			if !stable {
+				// The task fails once its single restart is used up
+				select {
+				case <-ctx.tr.WaitCh():
+				case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+					t.Fatalf("timeout")
+				}
+				ctx.upd.mu.Lock()
+				defer ctx.upd.mu.Unlock()
+				if !ctx.upd.failed {
+					t.Fatalf("expected task to have failed")
+				}
+				return
+			}
+
+			// The task keeps being restarted past its restart attempts
+			testutil.WaitForResult(func() (bool, error) {
+				ctx.upd.mu.Lock()
+				defer ctx.upd.mu.Unlock()
+				n := 0
+				for _, e := range ctx.upd.events {
+					if e.Type == structs.TaskStarted {
+						n++
+					}
+				}
+				if n < 4 {
+					return false, fmt.Errorf("expected at least 4 starts but found %d", n)
+				}
+				return true, nil
+			}, func(err error) {
+				t.Fatalf("err: %v", err)
+			})
+
+			select {
+			case <-ctx.tr.WaitCh():
+				t.Fatalf("expected task to keep restarting")
+			default:
+			}
+
+			ctx.tr.Kill("test", "kill", false)
+			select {
+			case <-ctx.tr.WaitCh():
+			case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+				t.Fatalf("timeout")
+			}
+		})
+	}
+}
+
+// TestTaskRunner_Watchdog asserts a warning event is emitted for batch tasks
+// that run past the watchdog timeout and that they are killed if configured,
+// while service tasks are not watched.
+func TestTaskRunner_Watchdog(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		jobType string
+		kill    bool
+		watched bool
+	}{
+		{jobType: structs.JobTypeBatch, kill: false, watched: true},
+		{jobType: structs.JobTypeBatch, kill: true, watched: true},
+		{jobType: structs.JobTypeService, kill: true, watched: false},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("%s/kill=%t", c.jobType, c.kill), func(t *testing.T) {
+			t.Parallel()
+			alloc := mock.Alloc()
+			alloc.Job.Type = c.jobType
+			task := alloc.Job.TaskGroups[0].Tasks[0]
+			task.Driver = "mock_driver"
+			task.Config = map[string]interface{}{
+				"run_for": "10s",
+			}
+
+			ctx := testTaskRunnerFromAlloc(t, false, alloc)
+			ctx.tr.config.TaskWatchdogTimeout = 100 * time.Millisecond
+			ctx.tr.config.TaskWatchdogKill = c.kill
+			ctx.tr.MarkReceived()
+			go ctx.tr.Run()
+			defer ctx.Cleanup()
+
+			testutil.WaitForResult(func() (bool, error) {
+				return ctx.tr.Running(), fmt.Errorf("task not running")
+			}, func(err error) {
+				t.Fatalf("err: %v", err)
+			})
+
+			killed := c.watched && c.kill
+			if !killed {
+				time.Sleep(300 * time.Millisecond)
+				if !ctx.tr.Running() {
+					t.Fatalf("expected task to still be running")
+				}
+				ctx.tr.Kill("test", "kill", false)
+			}
+
+			select {
+			case <-ctx.tr.WaitCh():
+			case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+				t.Fatalf("timeout")
+			}
+
+			ctx.upd.mu.Lock()
+			defer ctx.upd.mu.Unlock()
+			n := 0
+			for _, e := range ctx.upd.events {
+				if e.Type == structs.TaskDriverMessage && strings.Contains(e.DriverMessage, "watchdog timeout") {
+					n++
+				}
+			}
+			expected := 0
+			if c.watched {
+				expected = 1
+			}
+			if n != expected {
+				t.Fatalf("expected %d watchdog events; got %d: %v", expected, n, ctx.upd)
+			}
+			if ctx.upd.failed != killed {
+				t.Fatalf("expected failed=%t: %v", killed, ctx.upd)
+			}
+		})
+	}
+}
+
+// TestTaskRunner_Restart_Artifacts asserts unchanged artifacts are not
+// downloaded again when the task is restarted while changed ones are.
+func TestTaskRunner_Restart_Artifacts(t *testing.T) {
+	t.Parallel()
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("artifact"))
+	}))
+	defer ts.Close()
+
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "100s",
+	}
+	task.Artifacts = []*structs.TaskArtifact{
+		{GetterSource: fmt.Sprintf("%s/a.txt", ts.URL)},
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 download; got %d", n)
+	}
+
+	// Restarting skips the download
+	ctx.tr.Restart("test", "restart", false)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 2)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected artifacts to not be downloaded again; got %d downloads", n)
+	}
+
+	// Changing the artifacts restarts the task and downloads them again
+	updateAlloc := alloc.Copy()
+	updateAlloc.Job.TaskGroups[0].Tasks[0].Artifacts = []*structs.TaskArtifact{
+		{GetterSource: fmt.Sprintf("%s/b.txt", ts.URL)},
+	}
+	ctx.tr.Update(updateAlloc)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 3)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected changed artifacts to be downloaded; got %d downloads", n)
+	}
+	if _, err := os.Stat(filepath.Join(ctx.tr.taskDir.Dir, "b.txt")); err != nil {
+		t.Fatalf("changed artifact not downloaded: %v", err)
+	}
+
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// TestTaskRunner_Restart_Templates asserts unchanged templates are not
+// rendered again when the task is restarted while changed ones are.
+func TestTaskRunner_Restart_Templates(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "100s",
+	}
+	task.Templates = []*structs.Template{
+		{
+			EmbeddedTmpl: "one",
+			DestPath:     "local/test",
+			ChangeMode:   structs.TemplateChangeModeNoop,
+		},
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+	path := filepath.Join(ctx.tr.taskDir.LocalDir, "test")
+	testWaitForFile := func(expected string) {
+		testutil.WaitForResult(func() (bool, error) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return false, err
+			}
+			if string(data) != expected {
+				return false, fmt.Errorf("expected template to contain %q; got %q", expected, data)
+			}
+			return true, nil
+		}, func(err error) {
+			t.Fatalf("err: %v", err)
+		})
+	}
+	testWaitForFile("one")
+
+	// Restarting doesn't render the unchanged template again
+	if err := ioutil.WriteFile(path, []byte("modified"), 0666); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ctx.tr.Restart("test", "restart", false)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 2)
+	testWaitForFile("modified")
+
+	// Changing the template restarts the task and renders it again
+	updateAlloc := alloc.Copy()
+	updateAlloc.Job.TaskGroups[0].Tasks[0].Templates[0].EmbeddedTmpl = "two"
+	ctx.tr.Update(updateAlloc)
+	testWaitForTaskEvent(t, ctx, structs.TaskStarted, 3)
+	testWaitForFile("two")
+
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// TestTaskRunner_StartLimiter asserts that task runners sharing a start
+// limiter have their driver starts serialized by it.
+func TestTaskRunner_StartLimiter(t *testing.T) {
+	t.Parallel()
+	const block = 200 * time.Millisecond
+	limiter := make(chan struct{}, 1)
+
+	var ctxs []*taskRunnerTestCtx
+	for i := 0; i < 2; i++ {
+		alloc := mock.Alloc()
+		task := alloc.Job.TaskGroups[0].Tasks[0]
+		task.Driver = "mock_driver"
+		task.Config = map[string]interface{}{
+			"start_block_for": block.String(),
+			"run_for":         "10s",
+		}
+
+		ctx := testTaskRunnerFromAlloc(t, false, alloc)
+		defer ctx.Cleanup()
+		ctx.tr.config.StartLimiter = limiter
+		ctx.tr.MarkReceived()
+		ctxs = append(ctxs, ctx)
+	}
+
+	for _, ctx := range ctxs {
+		go ctx.tr.Run()
+	}
+
+	var started []time.Time
+	for _, ctx := range ctxs {
+		testWaitForTaskEvent(t, ctx, structs.TaskStarted, 1)
+		for _, e := range ctx.upd.events {
+			if e.Type == structs.TaskStarted {
+				started = append(started, time.Unix(0, e.Time))
+			}
+		}
+	}
+
+	diff := started[0].Sub(started[1])
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff < block*9/10 {
+		t.Fatalf("expected starts to be serialized by at least %v; got %v", block, diff)
+	}
+	if n := len(limiter); n != 0 {
+		t.Fatalf("expected start limiter slots to be released; %d held", n)
+	}
+
+	for _, ctx := range ctxs {
+		ctx.tr.Kill("test", "kill", false)
+		<-ctx.tr.WaitCh()
+	}
+}
+
+type traceIDKey struct{}
+
+// TestTaskRunner_Context_BaseContext asserts values carried by the client's
+// base context are visible to hooks through the task runner's context.
+func TestTaskRunner_Context_BaseContext(t *testing.T) {
+	t.Parallel()
+	ctx := testTaskRunner(t, false)
+	defer ctx.Cleanup()
+
+	conf := ctx.tr.config.Copy()
+	conf.BaseContext = context.WithValue(context.Background(), traceIDKey{}, "trace-1")
+	upd := &MockTaskStateUpdater{}
+	tr, err := NewTaskRunner(ctx.tr.logger, conf, ctx.tr.stateDB, upd.Update,
+		ctx.tr.taskDir, ctx.tr.alloc, ctx.tr.task, ctx.tr.vaultClient, ctx.tr.consul)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Read the value as a hook would from its own goroutine
+	valueCh := make(chan interface{}, 1)
+	go func() {
+		valueCh <- tr.Context().Value(traceIDKey{})
+	}()
+	if v := <-valueCh; v != "trace-1" {
+		t.Fatalf("expected trace id %q; got %v", "trace-1", v)
+	}
+
+	go tr.Run()
+	tr.Kill("test", "kill", false)
+	select {
+	case <-tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// The runner's context is cancelled without cancelling the base context
+	if tr.Context().Err() == nil {
+		t.Fatalf("expected task runner context to be cancelled")
+	}
+	if conf.BaseContext.Err() != nil {
+		t.Fatalf("expected base context to not be cancelled")
+	}
+}
+
+// TestTaskRunner_Restarting asserts Restarting is only true while the task is
+// waiting to be restarted.
+func TestTaskRunner_Restarting(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "1",
+		"run_for":   "10ms",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.restartTracker = restarts.NewRestartTracker(&structs.RestartPolicy{
+		Attempts: 1,
+		Interval: 10 * time.Minute,
+		Delay:    500 * time.Millisecond,
+		Mode:     structs.RestartPolicyModeFail,
+	}, structs.JobTypeService)
+	if ctx.tr.Restarting() {
+		t.Fatalf("expected new task to not be restarting")
+	}
+
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Restarting(), fmt.Errorf("expected task to be restarting")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	testutil.WaitForResult(func() (bool, error) {
+		return !ctx.tr.Restarting(), fmt.Errorf("expected task to no longer be restarting")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	n := 0
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskStarted {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("expected task to be restarted once; got %d starts: %v", n, ctx.upd)
+	}
+	if ctx.tr.Restarting() {
+		t.Fatalf("expected dead task to not be restarting")
+	}
+}
+
+// TestTaskRunner_Phase asserts the task runner's phase transitions through
+// prestart, running and stopping.
+func TestTaskRunner_Phase(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.ShutdownDelay = 500 * time.Millisecond
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	// Hold the task in prestart by filling the start limiter
+	ctx.tr.config.StartLimiter = make(chan struct{}, 1)
+	ctx.tr.config.StartLimiter <- struct{}{}
+
+	if phase := ctx.tr.Phase(); phase != TaskPhasePending {
+		t.Fatalf("expected phase %q; got %q", TaskPhasePending, phase)
+	}
+
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+
+	waitForPhase := func(expected string) {
+		testutil.WaitForResult(func() (bool, error) {
+			phase := ctx.tr.Phase()
+			return phase == expected, fmt.Errorf("expected phase %q; got %q", expected, phase)
+		}, func(err error) {
+			t.Fatalf("err: %v", err)
+		})
+	}
+
+	waitForPhase(TaskPhasePrestart)
+	<-ctx.tr.config.StartLimiter
+	waitForPhase(TaskPhaseRunning)
+
+	// The shutdown delay holds the task in the stopping phase
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	waitForPhase(TaskPhaseStopping)
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+	if phase := ctx.tr.Phase(); phase != TaskPhaseExited {
+		t.Fatalf("expected phase %q; got %q", TaskPhaseExited, phase)
+	}
+}
+
+// TestTaskRunner_Close asserts Close tears down the task runner, may be
+// called multiple times and returns immediately if the runner wasn't run.
+func TestTaskRunner_Close(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+	sink := &mockEventSink{}
+	ctx.tr.config.EventSink = sink
+	ctx.tr.eventSinkCh = make(chan *structs.TaskEvent, eventSinkBufferSize)
+	ctx.tr.eventSinkDoneCh = make(chan struct{})
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	ctx.tr.Close()
+	ctx.tr.Close()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	default:
+		t.Fatalf("expected task runner to have exited")
+	}
+	if err := ctx.tr.Context().Err(); err != context.Canceled {
+		t.Fatalf("expected context to be canceled; got %v", err)
+	}
+	if ctx.upd.state != structs.TaskStateDead {
+		t.Fatalf("expected task to be dead: %v", ctx.upd)
+	}
+	if n, expected := len(sink.Events()), len(ctx.upd.events); n != expected {
+		t.Fatalf("expected %d events emitted to the sink; got %d", expected, n)
+	}
+
+	// Closing a runner that was never run returns immediately
+	tr, cleanup := MockTaskRunner(t)
+	defer cleanup()
+	tr.Close()
+	tr.Close()
+}
+
+// TestTaskRunner_WaitForExit asserts WaitForExit returns once the task
+// runner exits or the context is done.
+func TestTaskRunner_WaitForExit(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Timed out
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ctx.tr.WaitForExit(timeoutCtx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	// Completed
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	exitCtx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(testutil.TestMultiplier()*15)*time.Second)
+	defer cancel()
+	if err := ctx.tr.WaitForExit(exitCtx); err != nil {
+		t.Fatalf("expected task runner to exit; got %v", err)
+	}
+}
+
+// TestTaskRunner_Update_Env asserts updating the alloc updates the task's
+// environment so it is used when the task is next started.
+func TestTaskRunner_Update_Env(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Env = map[string]string{"FOO": "foo"}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	updateAlloc := alloc.Copy()
+	updateAlloc.Job.TaskGroups[0].Tasks[0].Env["FOO"] = "bar"
+	updateAlloc.Job.TaskGroups[0].Tasks[0].Meta = map[string]string{"owner": "ops"}
+	if err := ctx.tr.handleUpdate(updateAlloc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	env := ctx.tr.envBuilder.Build().Map()
+	if v := env["FOO"]; v != "bar" {
+		t.Fatalf("expected FOO=bar; got %q", v)
+	}
+	if v := env["NOMAD_META_owner"]; v != "ops" {
+		t.Fatalf("expected NOMAD_META_owner=ops; got %q", v)
+	}
+}
+
+// TestTaskRunner_RestartPolicy asserts the task runner returns the restart
+// policy in effect, including after the task group's policy is updated.
+func TestTaskRunner_RestartPolicy(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	defer ctx.Cleanup()
+
+	policy := ctx.tr.RestartPolicy()
+	if !reflect.DeepEqual(policy, alloc.Job.TaskGroups[0].RestartPolicy) {
+		t.Fatalf("expected group policy %#v; got %#v", alloc.Job.TaskGroups[0].RestartPolicy, policy)
+	}
+
+	// Modifying the returned policy must not affect the runner
+	policy.Attempts = 100
+	if n := ctx.tr.RestartPolicy().Attempts; n == 100 {
+		t.Fatalf("expected returned policy to be a copy")
+	}
+
+	updateAlloc := alloc.Copy()
+	updatePolicy := updateAlloc.Job.TaskGroups[0].RestartPolicy
+	updatePolicy.Attempts = 7
+	updatePolicy.Mode = structs.RestartPolicyModeDelay
+	if err := ctx.tr.handleUpdate(updateAlloc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if policy := ctx.tr.RestartPolicy(); !reflect.DeepEqual(policy, updatePolicy) {
+		t.Fatalf("expected updated policy %#v; got %#v", updatePolicy, policy)
+	}
+}
+
+// TestTaskRunner_Postrun_Panic asserts WaitCh is closed even if stopping the
+// task panics.
+func TestTaskRunner_Postrun_Panic(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	// A template manager that was never started panics when stopped
+	ctx.tr.templateManager = &TaskTemplateManager{}
+
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// TestTaskRunner_FlushState_Panic asserts WaitCh is closed even if flushing the
+// task's state when Run exits panics.
+func TestTaskRunner_FlushState_Panic(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10ms",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	defer ctx.Cleanup()
+
+	// Keep a write pending until Run exits and panic when it is flushed
+	ctx.tr.config.TaskStateDebounce = time.Hour
+	ctx.tr.newStateHash = func() hash.Hash {
+		panic("failed to hash state")
+	}
+
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// TestTaskRunner_MemoryPressure asserts a single warning event is emitted
+// while the task's memory usage stays above the pressure threshold.
+func TestTaskRunner_MemoryPressure(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+	task.Resources.MemoryMB = 100
+	alloc.TaskResources[task.Name].MemoryMB = 100
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.config.StatsCollectionInterval = 10 * time.Millisecond
+	ctx.tr.config.TaskMemoryPressureThreshold = 0.9
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Phase() == TaskPhaseRunning, fmt.Errorf("task not started")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Report usage above the threshold for many collection intervals
+	ctx.tr.handleLock.Lock()
+	ctx.tr.handle = &statsHandle{DriverHandle: ctx.tr.handle, rss: 95 * 1024 * 1024}
+	ctx.tr.handleLock.Unlock()
+	time.Sleep(300 * time.Millisecond)
+
+	ctx.tr.Kill("test", "kill", false)
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	n := 0
+	for _, e := range ctx.upd.events {
+		if e.Type == structs.TaskDriverMessage && strings.Contains(e.DriverMessage, "memory usage") {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 memory pressure event; got %d: %v", n, ctx.upd)
+	}
+}
+
+// TestTaskRunner_PersistState_Debounce asserts a burst of state changes is
+// coalesced into one write and that saving the state flushes a pending write.
+func TestTaskRunner_PersistState_Debounce(t *testing.T) {
+	t.Parallel()
+	tr, cleanup := MockTaskRunner(t)
+	defer cleanup()
+	tr.config.TaskStateDebounce = 100 * time.Millisecond
+
+	persisted := func() []byte {
+		tr.persistLock.Lock()
+		defer tr.persistLock.Unlock()
+		return tr.persistedHash
+	}
+
+	// Change the state several times within the debounce window
+	for i := 0; i < 5; i++ {
+		tr.persistLock.Lock()
+		tr.payloadRendered = i%2 == 0
+		tr.persistLock.Unlock()
+		tr.persistState()
+	}
+	if h := persisted(); h != nil {
+		t.Fatalf("expected no write within the debounce window")
+	}
+
+	// Only the last state is written once the window elapses
+	expected := tr.LocalStateSnapshot().Hash()
+	testutil.WaitForResult(func() (bool, error) {
+		if !bytes.Equal(persisted(), expected) {
+			return false, fmt.Errorf("expected the last state to be written")
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Saving the state writes a pending change immediately
+	tr.persistLock.Lock()
+	tr.taskDirBuilt = !tr.taskDirBuilt
+	tr.persistLock.Unlock()
+	tr.persistState()
+	if err := tr.SaveState(); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	if !bytes.Equal(persisted(), tr.LocalStateSnapshot().Hash()) {
+		t.Fatalf("expected pending state to be written")
+	}
+	tr.persistTimerLock.Lock()
+	defer tr.persistTimerLock.Unlock()
+	if tr.persistTimer != nil {
+		t.Fatalf("expected pending write to be stopped")
+	}
+}
+
+// staticHash is a hash whose sum ignores the data written to it
+type staticHash struct {
+	hash.Hash
+	sum []byte
+}
+
+func (h staticHash) Sum(b []byte) []byte {
+	return append(b, h.sum...)
+}
+
+// TestTaskRunner_SaveState_Hash asserts the state is only written when its
+// hash differs from the hash of the persisted state.
+func TestTaskRunner_SaveState_Hash(t *testing.T) {
+	t.Parallel()
+	tr, cleanup := MockTaskRunner(t)
+	defer cleanup()
+
+	sum := []byte("a")
+	tr.newStateHash = func() hash.Hash {
+		return staticHash{Hash: md5.New(), sum: sum}
+	}
+
+	// persisted returns the created resource of the persisted state
+	persisted := func() string {
+		var snap LocalState
+		err := tr.stateDB.View(func(tx *bolt.Tx) error {
+			bkt, err := state.GetTaskBucket(tx, tr.alloc.ID, tr.task.Name)
+			if err != nil {
+				return err
+			}
+			return state.GetObject(bkt, taskRunnerStateAllKey, &snap)
+		})
+		if err != nil {
+			t.Fatalf("error reading state: %v", err)
+		}
+		return snap.CreatedResources.Resources["test"][0]
+	}
+	save := func(resource string) {
+		res := driver.NewCreatedResources()
+		res.Add("test", resource)
+		tr.setCreatedResources(res)
+		if err := tr.SaveState(); err != nil {
+			t.Fatalf("error saving state: %v", err)
+		}
+	}
+
+	// The first save is written
+	save("1")
+	if r := persisted(); r != "1" {
+		t.Fatalf("expected persisted resource 1 but found %q", r)
+	}
+
+	// The write is skipped when the hash matches the persisted hash
+	save("2")
+	if r := persisted(); r != "1" {
+		t.Fatalf("expected write to be skipped but found resource %q", r)
+	}
+
+	// The write happens once the hash differs
+	sum = []byte("b")
+	save("3")
+	if r := persisted(); r != "3" {
+		t.Fatalf("expected persisted resource 3 but found %q", r)
+	}
+}
+
+// TestTaskRunner_SaveState_SizeMetric asserts the size of the persisted state
+// is reported when the state is saved.
+func TestTaskRunner_SaveState_SizeMetric(t *testing.T) {
+	// Capture metrics in memory
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+
+	ctx := testTaskRunner(t, false)
+	defer ctx.Cleanup()
+
+	if err := ctx.tr.SaveState(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, structs.MsgpackHandle).Encode(ctx.tr.LocalStateSnapshot()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	data := sink.Data()
+	intv := data[len(data)-1]
+	intv.RLock()
+	defer intv.RUnlock()
+	for _, g := range intv.Gauges {
+		if g.Name != "client.allocs.state_size_bytes" {
+			continue
+		}
+		for _, l := range g.Labels {
+			if l.Name == "alloc_id" && l.Value == ctx.tr.AllocID() {
+				if g.Value != float32(buf.Len()) {
+					t.Fatalf("expected state size %d; got %v", buf.Len(), g.Value)
+				}
+				return
+			}
+		}
+	}
+	t.Fatalf("state size gauge not found")
+}
+
+// TestTaskRunner_MetricsPaused asserts no metrics are emitted while allocation
+// metrics are paused and that they resume afterwards.
+func TestTaskRunner_MetricsPaused(t *testing.T) {
+	// Capture metrics in memory
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+
+	ctx := testTaskRunner(t, false)
+	defer ctx.Cleanup()
+	ctx.tr.config.PublishAllocationMetrics = true
+	ctx.tr.runningLock.Lock()
+	ctx.tr.running = true
+	ctx.tr.runningLock.Unlock()
+
+	// found returns whether the gauge has been emitted for the task
+	found := func(name string) bool {
+		data := sink.Data()
+		intv := data[len(data)-1]
+		intv.RLock()
+		defer intv.RUnlock()
+		for _, g := range intv.Gauges {
+			if g.Name != name {
+				continue
+			}
+			for _, l := range g.Labels {
+				if l.Name == "alloc_id" && l.Value == ctx.tr.AllocID() {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	ru := &cstructs.TaskResourceUsage{
+		ResourceUsage: &cstructs.ResourceUsage{
+			MemoryStats: &cstructs.MemoryStats{RSS: 100},
+			CpuStats:    &cstructs.CpuStats{},
+		},
+	}
+
+	ctx.tr.config.AllocMetrics.SetPaused(true)
+	ctx.tr.emitStats(ru)
+	if err := ctx.tr.SaveState(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if found("client.allocs.memory.rss") || found("client.allocs.state_size_bytes") {
+		t.Fatalf("expected no metrics to be emitted while paused")
+	}
+
+	ctx.tr.config.AllocMetrics.SetPaused(false)
+	ctx.tr.emitStats(ru)
+	if !found("client.allocs.memory.rss") {
+		t.Fatalf("expected metrics to be emitted once resumed")
+	}
+}
+
+// statsHandle is a driver handle that reports fixed resource usage
+type statsHandle struct {
+	driver.DriverHandle
+	rss uint64
+}
+
+func (h *statsHandle) Stats() (*cstructs.TaskResourceUsage, error) {
+	return &cstructs.TaskResourceUsage{
+		ResourceUsage: &cstructs.ResourceUsage{
+			MemoryStats: &cstructs.MemoryStats{RSS: h.rss},
+			CpuStats:    &cstructs.CpuStats{},
+		},
+		Timestamp: time.Now().UTC().UnixNano(),
+	}, nil
+}
+
+// TestTaskRunner_Stats_ZeroedOnExit asserts the task's resource usage gauges
+// return to zero once the task dies.
+func TestTaskRunner_Stats_ZeroedOnExit(t *testing.T) {
+	// Capture metrics in memory
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "500ms",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.config.PublishAllocationMetrics = true
+	ctx.tr.config.StatsCollectionInterval = 10 * time.Millisecond
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Phase() == TaskPhaseRunning, fmt.Errorf("task not started")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+	ctx.tr.handleLock.Lock()
+	ctx.tr.handle = &statsHandle{DriverHandle: ctx.tr.handle, rss: 100}
+	ctx.tr.handleLock.Unlock()
+
+	rss := func() (float32, bool) {
+		data := sink.Data()
+		intv := data[len(data)-1]
+		intv.RLock()
+		defer intv.RUnlock()
+		for _, g := range intv.Gauges {
+			if g.Name != "client.allocs.memory.rss" {
+				continue
+			}
+			for _, l := range g.Labels {
+				if l.Name == "alloc_id" && l.Value == alloc.ID {
+					return g.Value, true
+				}
+			}
+		}
+		return 0, false
 	}
 
-	if ctx.upd.events[6].Type != structs.TaskRestarting {
-		t.Fatalf("Seventh Event was %v; want %v", ctx.upd.events[6].Type, structs.TaskRestarting)
-	}
+	testutil.WaitForResult(func() (bool, error) {
+		v, ok := rss()
+		return ok && v == 100, fmt.Errorf("expected rss gauge of 100; got %v", v)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
 
-	if ctx.upd.events[7].Type != structs.TaskStarted {
-		t.Fatalf("Eighth Event was %v; want %v", ctx.upd.events[8].Type, structs.TaskStarted)
-	}
-	if ctx.upd.events[8].Type != structs.TaskKilling {
-		t.Fatalf("Ninth  Event was %v; want %v", ctx.upd.events[8].Type, structs.TaskKilling)
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
 	}
 
-	if ctx.upd.events[9].Type != structs.TaskKilled {
-		t.Fatalf("Tenth Event was %v; want %v", ctx.upd.events[9].Type, structs.TaskKilled)
-	}
+	testutil.WaitForResult(func() (bool, error) {
+		v, _ := rss()
+		return v == 0, fmt.Errorf("expected rss gauge to be zeroed; got %v", v)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
 }
 
 func TestTaskRunner_KillTask(t *testing.T) {
@@ -1615,6 +4283,79 @@ func TestTaskRunner_CleanupFail(t *testing.T) {
 	}
 }
 
+// TestTaskRunner_GCTaskLocalDirs asserts a task's local data is removed once
+// the task is dead but not while it is waiting to be restarted.
+func TestTaskRunner_GCTaskLocalDirs(t *testing.T) {
+	t.Parallel()
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "0",
+		"run_for":   "10ms",
+	}
+
+	// Restart once before failing
+	alloc.Job.TaskGroups[0].RestartPolicy = &structs.RestartPolicy{
+		Attempts: 1,
+		Interval: 10 * time.Minute,
+		Delay:    1 * time.Second,
+		Mode:     structs.RestartPolicyModeFail,
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.config.GCTaskLocalDirs = true
+	defer ctx.Cleanup()
+
+	localFile := filepath.Join(ctx.tr.taskDir.LocalDir, "data")
+	sharedFile := filepath.Join(ctx.tr.taskDir.SharedAllocDir, "shared")
+	for _, f := range []string{localFile, sharedFile} {
+		if err := ioutil.WriteFile(f, []byte("foo"), 0666); err != nil {
+			t.Fatalf("error writing %q: %v", f, err)
+		}
+	}
+
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+
+	// Wait for the task to be restarting
+	testutil.WaitForResult(func() (bool, error) {
+		for _, e := range ctx.upd.events {
+			if e.Type == structs.TaskRestarting {
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("task not restarting: %v", ctx.upd)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Local data must be kept between restarts
+	if _, err := os.Stat(localFile); err != nil {
+		t.Fatalf("expected local data to be kept while restarting: %v", err)
+	}
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if ctx.upd.state != structs.TaskStateDead {
+		t.Fatalf("TaskState %v; want %v", ctx.upd.state, structs.TaskStateDead)
+	}
+
+	// Local data must be removed once the task is dead
+	if _, err := os.Stat(localFile); !os.IsNotExist(err) {
+		t.Fatalf("expected local data to be removed: %v", err)
+	}
+
+	// Shared alloc data must be preserved
+	if _, err := os.Stat(sharedFile); err != nil {
+		t.Fatalf("expected shared alloc data to be kept: %v", err)
+	}
+}
+
 func TestTaskRunner_Pre06ScriptCheck(t *testing.T) {
 	t.Parallel()
 	run := func(ver, driver, checkType string, exp bool) (string, func(t *testing.T)) {
@@ -1907,6 +4648,270 @@ func TestTaskRunner_CheckWatcher_Restart(t *testing.T) {
 	}
 }
 
+type mockNetworkHook struct {
+	ip string
+}
+
+func (h *mockNetworkHook) Name() string {
+	return "cni"
+}
+
+func (h *mockNetworkHook) UpdateNetwork(net *cstructs.DriverNetwork) (*cstructs.DriverNetwork, error) {
+	net.IP = h.ip
+	return net, nil
+}
+
+// TestTaskRunner_NetworkHook asserts the network returned by a network hook
+// is used in place of the driver's network.
+func TestTaskRunner_NetworkHook(t *testing.T) {
+	t.Parallel()
+
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for":   "100s",
+		"driver_ip": "10.1.2.3",
+	}
+	task.Services = []*structs.Service{
+		{
+			Name:        "driver-service",
+			PortLabel:   "5678",
+			AddressMode: "driver",
+		},
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.AddNetworkHook(&mockNetworkHook{ip: "10.9.8.7"})
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	testutil.WaitForResult(func() (bool, error) {
+		services, _ := ctx.consul.Services()
+		if n := len(services); n != 1 {
+			return false, fmt.Errorf("expected 1 service, but found %d", n)
+		}
+		for _, s := range services {
+			if expected := "10.9.8.7"; s.Address != expected {
+				return false, fmt.Errorf("expected %s to have IP=%s but found %s",
+					s.Service, expected, s.Address)
+			}
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("error: %v", err)
+	})
+
+	if ip := ctx.tr.LocalStateSnapshot().DriverNetwork.IP; ip != "10.9.8.7" {
+		t.Fatalf("expected persisted driver network IP 10.9.8.7 but found %s", ip)
+	}
+
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// TestTaskRunner_OnHandleChange asserts the client's OnHandleChange func is
+// called when the task is started and when its handle is cleared before a
+// restart.
+func TestTaskRunner_OnHandleChange(t *testing.T) {
+	t.Parallel()
+
+	alloc := mock.Alloc()
+	*alloc.Job.TaskGroups[0].RestartPolicy = structs.RestartPolicy{
+		Attempts: 1,
+		Interval: 10 * time.Minute,
+		Delay:    10 * time.Millisecond,
+		Mode:     structs.RestartPolicyModeFail,
+	}
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"exit_code": "1",
+		"run_for":   "10ms",
+	}
+
+	type change struct {
+		old, new config.TaskHandle
+	}
+	var changes []change
+	var lock sync.Mutex
+
+	ctx := testTaskRunnerFromAlloc(t, true, alloc)
+	ctx.tr.config.OnHandleChange = func(allocID, taskName string, old, new config.TaskHandle) {
+		if allocID != alloc.ID || taskName != task.Name {
+			t.Errorf("unexpected task %q of alloc %q", taskName, allocID)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		changes = append(changes, change{old, new})
+	}
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Started, cleared for the restart and started again
+	if n := len(changes); n != 3 {
+		t.Fatalf("expected 3 handle changes but found %d", n)
+	}
+	if changes[0].old != nil || changes[0].new == nil {
+		t.Fatalf("expected a handle to be set on start but found %#v", changes[0])
+	}
+	if changes[1].old != changes[0].new || changes[1].new != nil {
+		t.Fatalf("expected the handle to be cleared but found %#v", changes[1])
+	}
+	if changes[2].old != nil || changes[2].new == nil || changes[2].new == changes[0].new {
+		t.Fatalf("expected a new handle to be set on restart but found %#v", changes[2])
+	}
+}
+
+// TestTaskRunner_OnHandleChange_Restore asserts the client's OnHandleChange
+// func is called with the handle of a restored task.
+func TestTaskRunner_OnHandleChange_Restore(t *testing.T) {
+	t.Parallel()
+
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "10s",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	ctx.tr.MarkReceived()
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+	handleID := ctx.tr.getHandle().ID()
+
+	// Stop the first runner without destroying its state
+	ctx.tr.Shutdown()
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// Restore a new task runner with the func set in its config
+	var restored config.TaskHandle
+	conf := ctx.tr.config.Copy()
+	conf.OnHandleChange = func(allocID, taskName string, old, new config.TaskHandle) {
+		if old == nil {
+			restored = new
+		}
+	}
+	upd2 := &MockTaskStateUpdater{}
+	tr2, err := NewTaskRunner(ctx.tr.logger, conf, ctx.tr.stateDB, upd2.Update,
+		ctx.tr.taskDir, ctx.tr.alloc, task.Copy(), ctx.tr.vaultClient, ctx.tr.consul)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := tr2.RestoreState(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer tr2.getHandle().Kill()
+
+	if restored == nil || restored.ID() != handleID {
+		t.Fatalf("expected the restored handle %q to be reported but found %#v", handleID, restored)
+	}
+}
+
+type blockingNetworkHook struct {
+	unblockCh chan struct{}
+}
+
+func (h *blockingNetworkHook) Name() string {
+	return "blocking"
+}
+
+func (h *blockingNetworkHook) UpdateNetwork(net *cstructs.DriverNetwork) (*cstructs.DriverNetwork, error) {
+	<-h.unblockCh
+	return net, nil
+}
+
+// TestTaskRunner_CurrentHook asserts the hook currently executing is reported
+// and cleared once it returns.
+func TestTaskRunner_CurrentHook(t *testing.T) {
+	t.Parallel()
+
+	alloc := mock.Alloc()
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for":   "100s",
+		"driver_ip": "10.1.2.3",
+	}
+
+	ctx := testTaskRunnerFromAlloc(t, false, alloc)
+	hook := &blockingNetworkHook{unblockCh: make(chan struct{})}
+	ctx.tr.AddNetworkHook(hook)
+	ctx.tr.MarkReceived()
+
+	if name, phase, _ := ctx.tr.CurrentHook(); name != "" || phase != "" {
+		t.Fatalf("expected no current hook but found %q in phase %q", name, phase)
+	}
+
+	go ctx.tr.Run()
+	defer ctx.Cleanup()
+
+	testutil.WaitForResult(func() (bool, error) {
+		name, phase, since := ctx.tr.CurrentHook()
+		if name != "blocking" || phase != HookPhaseNetwork {
+			return false, fmt.Errorf("expected current hook blocking in phase %q but found %q in phase %q",
+				HookPhaseNetwork, name, phase)
+		}
+		if since.IsZero() {
+			return false, fmt.Errorf("expected current hook start time to be set")
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	close(hook.unblockCh)
+
+	testutil.WaitForResult(func() (bool, error) {
+		return ctx.tr.Running(), fmt.Errorf("task not running")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	if name, phase, since := ctx.tr.CurrentHook(); name != "" || phase != "" || !since.IsZero() {
+		t.Fatalf("expected no current hook but found %q in phase %q since %v", name, phase, since)
+	}
+
+	ctx.tr.Destroy(structs.NewTaskEvent(structs.TaskKilled))
+	select {
+	case <-ctx.tr.WaitCh():
+	case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
 // TestTaskRunner_DriverNetwork asserts that a driver's network is properly
 // used in services and checks.
 func TestTaskRunner_DriverNetwork(t *testing.T) {
@@ -0,0 +1,104 @@
+package taskrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/nomad/client/allocdir"
+	"github.com/hashicorp/nomad/client/config"
+	consulApi "github.com/hashicorp/nomad/client/consul"
+	cstructs "github.com/hashicorp/nomad/client/structs"
+	"github.com/hashicorp/nomad/client/vaultclient"
+	"github.com/hashicorp/nomad/helper/testlog"
+	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/testutil"
+)
+
+// MockTaskRunner returns a TaskRunner for the first task of a mock batch
+// allocation using the mock driver. The task runs for 500ms and is not
+// restarted. The runner's state db, alloc dir and task dir are
+// created in a temporary directory that is removed by the returned cleanup
+// func.
+//
+// Callers should defer the cleanup func, which closes the runner and waits for
+// Run to return if it was started.
+func MockTaskRunner(t *testing.T) (*TaskRunner, func()) {
+	alloc := mock.Alloc()
+	alloc.Job.Type = structs.JobTypeBatch
+	*alloc.Job.TaskGroups[0].RestartPolicy = structs.RestartPolicy{
+		Attempts: 0,
+		Mode:     structs.RestartPolicyModeFail,
+	}
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	task.Driver = "mock_driver"
+	task.Config = map[string]interface{}{
+		"run_for": "500ms",
+	}
+	return MockTaskRunnerFromAlloc(t, alloc)
+}
+
+// MockTaskRunnerFromAlloc returns a TaskRunner for the first task in the first
+// task group of the passed allocation. See MockTaskRunner for details.
+func MockTaskRunnerFromAlloc(t *testing.T, alloc *structs.Allocation) (*TaskRunner, func()) {
+	dir, err := ioutil.TempDir("", "nomad-taskrunner")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+
+	conf := config.DefaultConfig()
+	conf.Node = mock.Node()
+	conf.StateDir = filepath.Join(dir, "state")
+	conf.AllocDir = filepath.Join(dir, "alloc")
+
+	db, err := bolt.Open(filepath.Join(dir, "state.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("error creating state db: %v", err)
+	}
+
+	allocDir := allocdir.NewAllocDir(testlog.Logger(t), filepath.Join(conf.AllocDir, alloc.ID))
+	if err := allocDir.Build(); err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("error building alloc dir: %v", err)
+	}
+
+	task := alloc.Job.TaskGroups[0].Tasks[0]
+	taskDir := allocDir.NewTaskDir(task.Name)
+	if err := taskDir.Build(false, config.DefaultChrootEnv, cstructs.FSIsolationNone); err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("error building task dir %q: %v", task.Name, err)
+	}
+
+	updater := func(string, string, *structs.TaskEvent, bool) {}
+	vclient := vaultclient.NewMockVaultClient()
+	cclient := consulApi.NewMockConsulServiceClient(t)
+	tr, err := NewTaskRunner(testlog.Logger(t), conf, db, updater, taskDir, alloc, task, vclient, cclient)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("error creating task runner: %v", err)
+	}
+
+	cleanup := func() {
+		closed := make(chan struct{})
+		go func() {
+			tr.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Duration(testutil.TestMultiplier()*15) * time.Second):
+			t.Errorf("timeout waiting for task runner to exit")
+		}
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	return tr, cleanup
+}
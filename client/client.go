@@ -435,6 +435,11 @@ func (c *Client) reloadTLSConnections(newConfig *nconfig.TLSConfig) error {
 
 // Reload allows a client to reload its configuration on the fly
 func (c *Client) Reload(newConfig *config.Config) error {
+	// The toggle is shared by the alloc runners so it is updated in place
+	if c.config.AllocMetrics != nil {
+		c.config.AllocMetrics.SetPaused(newConfig.AllocMetrics.Paused())
+	}
+
 	shouldReloadTLS, err := tlsutil.ShouldReloadRPCConnections(c.config.TLSConfig, newConfig.TLSConfig)
 	if err != nil {
 		c.logger.Printf("[ERR] nomad: error parsing server TLS configuration: %s", err)
@@ -530,6 +535,17 @@ func (c *Client) Shutdown() error {
 			}(ar)
 		}
 		wg.Wait()
+	} else {
+		// Leave the tasks running so they are recovered on restart
+		var wg sync.WaitGroup
+		for _, ar := range c.getAllocRunners() {
+			wg.Add(1)
+			go func(ar *allocrunner.AllocRunner) {
+				ar.Shutdown()
+				wg.Done()
+			}(ar)
+		}
+		wg.Wait()
 	}
 
 	c.shutdown = true
@@ -1,17 +1,20 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/nomad/helper"
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/nomad/structs/config"
 	"github.com/hashicorp/nomad/version"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -99,6 +102,38 @@ type Config struct {
 	// used.
 	MaxKillTimeout time.Duration
 
+	// KillBackoffBaseline and KillBackoffLimit override the baseline and limit
+	// of the exponential backoff between failed attempts to kill a task. If
+	// zero the task runner's defaults are used.
+	KillBackoffBaseline time.Duration
+	KillBackoffLimit    time.Duration
+
+	// TaskLivenessInterval is the interval at which running tasks emit an
+	// event showing they are still running. If zero no events are emitted.
+	TaskLivenessInterval time.Duration
+
+	// TaskWatchdogTimeout is how long a task of a batch job may run without
+	// exiting before a warning event is emitted. If zero tasks are not
+	// watched.
+	TaskWatchdogTimeout time.Duration
+
+	// TaskWatchdogKill kills tasks that run past the TaskWatchdogTimeout.
+	TaskWatchdogKill bool
+
+	// TaskMemoryPressureThreshold is the fraction of a task's memory limit
+	// above which a warning event is emitted. If zero no events are emitted.
+	TaskMemoryPressureThreshold float64
+
+	// TaskStableUptime is how long a task must run before exiting for its
+	// restart attempts to be reset. If zero attempts are only reset at the
+	// end of the restart policy's interval.
+	TaskStableUptime time.Duration
+
+	// TaskStateDebounce delays writing a task runner's state after it changes
+	// so a burst of changes is coalesced into one write. If zero state is
+	// written on every change.
+	TaskStateDebounce time.Duration
+
 	// Servers is a list of known server addresses. These are as "host:port"
 	Servers []string
 
@@ -152,6 +187,11 @@ type Config struct {
 	// allocation metrics to remote Telemetry sinks
 	PublishAllocationMetrics bool
 
+	// AllocMetrics pauses and resumes emitting allocation and task metrics at
+	// runtime. It is shared by copies of the config so it can be toggled for
+	// all running allocations.
+	AllocMetrics *MetricsToggle
+
 	// TLSConfig holds various TLS related configurations
 	TLSConfig *config.TLSConfig
 
@@ -175,6 +215,27 @@ type Config struct {
 	// before garbage collection is triggered.
 	GCMaxAllocs int
 
+	// GCTaskLocalDirs enables removing a task's local and secrets
+	// directories once the task is dead and will not be restarted. Data in
+	// the shared alloc directory is preserved.
+	GCTaskLocalDirs bool
+
+	// KillLimiter bounds the rate of kill attempts across all tasks on the
+	// node. It is shared by every task runner, and a nil limiter does not
+	// limit kills.
+	KillLimiter *rate.Limiter
+
+	// StartLimiter is a semaphore bounding the number of concurrent driver
+	// starts across all tasks on the node. A slot is acquired by sending to
+	// it before starting a task and released by receiving from it once the
+	// driver returns. A nil limiter does not limit starts.
+	StartLimiter chan struct{}
+
+	// BaseContext, if set, is the parent of each task runner's context so
+	// values it carries, such as trace and span IDs, are visible to hooks.
+	// Cancelling it cancels every task runner's context.
+	BaseContext context.Context
+
 	// LogLevel is the level of the logs to putout
 	LogLevel string
 
@@ -199,6 +260,28 @@ type Config struct {
 	// displaying metrics for older versions, or to only show the new format
 	BackwardsCompatibleMetrics bool
 
+	// AttributeSink, if set, receives task identity as OpenTelemetry style
+	// attributes in parallel with the go-metrics labels.
+	AttributeSink AttributeSink
+
+	// EventSink, if set, receives every task event emitted by the task
+	// runners, such as for shipping them to an audit log.
+	EventSink EventSink
+
+	// EventSinkBufferSize is the number of events buffered per task for the
+	// EventSink. If zero a default is used.
+	EventSinkBufferSize int
+
+	// EventSinkBlock makes task runners wait for room in the EventSink buffer
+	// when it is full, applying backpressure to task state updates, instead
+	// of dropping the event.
+	EventSinkBlock bool
+
+	// OnHandleChange, if set, is called each time the driver handle of a
+	// task changes: when the task is started or recovered, and when the
+	// handle is cleared before the task is restarted or paused.
+	OnHandleChange HandleChangeFunc
+
 	// RPCHoldTimeout is how long an RPC can be "held" before it is errored.
 	// This is used to paper over a loss of leadership by instead holding RPCs,
 	// so that the caller experiences a slow response rather than an error.
@@ -219,6 +302,58 @@ func (c *Config) Copy() *Config {
 	return nc
 }
 
+// Attribute is an OpenTelemetry style key/value attribute.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// AttributeSink receives the identity of a task as OpenTelemetry style
+// attributes when the task transitions between states.
+type AttributeSink interface {
+	// EmitTaskState is called when a task transitions to the given state.
+	EmitTaskState(state string, attrs []Attribute)
+}
+
+// EventSink receives the task events emitted by task runners. Events for a
+// task are delivered in order from a single goroutine.
+type EventSink interface {
+	// EmitTaskEvent is called with each event emitted for the task.
+	EmitTaskEvent(allocID, taskName string, event *structs.TaskEvent)
+}
+
+// TaskHandle is the driver handle of a task passed to a HandleChangeFunc. It is
+// implemented by the handles returned by drivers.
+type TaskHandle interface {
+	// ID returns an opaque handle that can be used to re-open the handle.
+	ID() string
+}
+
+// HandleChangeFunc is called with the previous and new driver handle of a task
+// when it changes, either of which may be nil. It is called from the task
+// runners' goroutines so it must not block.
+type HandleChangeFunc func(allocID, taskName string, old, new TaskHandle)
+
+// MetricsToggle pauses and resumes emitting metrics at runtime, such as during
+// a mass redeploy. A nil MetricsToggle is never paused.
+type MetricsToggle struct {
+	paused int32
+}
+
+// SetPaused pauses or resumes emitting metrics.
+func (t *MetricsToggle) SetPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&t.paused, v)
+}
+
+// Paused returns whether emitting metrics is paused.
+func (t *MetricsToggle) Paused() bool {
+	return t != nil && atomic.LoadInt32(&t.paused) == 1
+}
+
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -238,6 +373,7 @@ func DefaultConfig() *Config {
 		NoHostUUID:                 true,
 		DisableTaggedMetrics:       false,
 		BackwardsCompatibleMetrics: false,
+		AllocMetrics:               &MetricsToggle{},
 		RPCHoldTimeout:             5 * time.Second,
 	}
 }
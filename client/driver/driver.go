@@ -65,6 +65,11 @@ type PrestartResponse struct {
 	// Prestart, it will only be used for creating an environment for
 	// Start. It will be overridden by the DriverNetwork returned by Start.
 	Network *cstructs.DriverNetwork
+
+	// Env contains environment variables the driver requires for the task.
+	// They are added to the environment used for Start and may be overridden
+	// by the task's own environment variables.
+	Env map[string]string
 }
 
 // NewPrestartResponse creates a new PrestartResponse with CreatedResources
@@ -189,6 +189,9 @@ type Builder struct {
 	// templateEnv are env vars set from templates
 	templateEnv map[string]string
 
+	// driverEnv are env vars returned by the driver's Prestart
+	driverEnv map[string]string
+
 	// hostEnv are environment variables filtered from the host
 	hostEnv map[string]string
 
@@ -335,6 +338,11 @@ func (b *Builder) Build() *TaskEnv {
 		envMap[k] = hargs.ReplaceEnv(v, nodeAttrs, envMap)
 	}
 
+	// Copy driver env vars as they override host env vars
+	for k, v := range b.driverEnv {
+		envMap[k] = v
+	}
+
 	// Copy interpolated task env vars second as they override host env vars
 	for k, v := range b.envvars {
 		envMap[k] = hargs.ReplaceEnv(v, nodeAttrs, envMap)
@@ -559,6 +567,15 @@ func (b *Builder) SetTemplateEnv(m map[string]string) *Builder {
 	return b
 }
 
+// SetDriverEnv sets the environment variables returned by the driver's
+// Prestart. Task and template env vars take precedence over them.
+func (b *Builder) SetDriverEnv(m map[string]string) *Builder {
+	b.mu.Lock()
+	b.driverEnv = m
+	b.mu.Unlock()
+	return b
+}
+
 func (b *Builder) SetVaultToken(token string, inject bool) *Builder {
 	b.mu.Lock()
 	b.vaultToken = token
@@ -268,6 +268,22 @@ func TestEnvironment_Envvars(t *testing.T) {
 	}
 }
 
+func TestEnvironment_DriverEnv(t *testing.T) {
+	a := mock.Alloc()
+	task := a.Job.TaskGroups[0].Tasks[0]
+	task.Env = map[string]string{"foo": "task"}
+	driverEnv := map[string]string{"foo": "driver", "bar": "driver"}
+	act := NewBuilder(mock.Node(), a, task, "global").SetDriverEnv(driverEnv).Build().Map()
+
+	// Task env vars override the driver's
+	if v := act["foo"]; v != "task" {
+		t.Fatalf("expected foo=%q but found %q", "task", v)
+	}
+	if v := act["bar"]; v != "driver" {
+		t.Fatalf("expected bar=%q but found %q", "driver", v)
+	}
+}
+
 func TestEnvironment_Interpolate(t *testing.T) {
 	n := mock.Node()
 	n.Attributes["arch"] = "x86"
@@ -44,6 +44,9 @@ type MockDriverConfig struct {
 	// StartErrRecoverable marks the error returned is recoverable
 	StartErrRecoverable bool `mapstructure:"start_error_recoverable"`
 
+	// StartErrType returns the start error as a "resource" or "auth" error
+	StartErrType string `mapstructure:"start_error_type"`
+
 	// StartBlockFor specifies a duration in which to block before returning
 	StartBlockFor time.Duration `mapstructure:"start_block_for"`
 
@@ -154,7 +157,14 @@ func (m *MockDriver) Start(ctx *ExecContext, task *structs.Task) (*StartResponse
 	}
 
 	if driverConfig.StartErr != "" {
-		return nil, structs.NewRecoverableError(errors.New(driverConfig.StartErr), driverConfig.StartErrRecoverable)
+		err := errors.New(driverConfig.StartErr)
+		switch driverConfig.StartErrType {
+		case "resource":
+			return nil, dstructs.NewResourceError(err)
+		case "auth":
+			return nil, dstructs.NewAuthError(err)
+		}
+		return nil, structs.NewRecoverableError(err, driverConfig.StartErrRecoverable)
 	}
 
 	// Create the driver network
@@ -1,8 +1,11 @@
 package structs
 
 import (
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
 )
 
 const (
@@ -28,6 +31,15 @@ func NewWaitResult(code, signal int, err error) *WaitResult {
 	}
 }
 
+// Copy returns a copy of the wait result.
+func (r *WaitResult) Copy() *WaitResult {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
 func (r *WaitResult) Successful() bool {
 	return r.ExitCode == 0 && r.Signal == 0 && r.Err == nil
 }
@@ -65,3 +77,63 @@ type ExecutorConfig struct {
 	// LogLevel is the level of the logs to putout
 	LogLevel string
 }
+
+// ResourceError is returned by drivers when a task couldn't be started because
+// the node ran out of a resource, such as memory or disk space. It is
+// recoverable as the resource may be freed.
+type ResourceError struct {
+	Err error
+}
+
+// NewResourceError is used to wrap an error caused by running out of a
+// resource.
+func NewResourceError(e error) error {
+	if e == nil {
+		return nil
+	}
+	return &ResourceError{Err: e}
+}
+
+func (r *ResourceError) Error() string {
+	return r.Err.Error()
+}
+
+func (r *ResourceError) IsRecoverable() bool {
+	return true
+}
+
+// AuthError is returned by drivers when a task couldn't be started because
+// authenticating failed, such as when pulling an image with invalid
+// credentials. It isn't recoverable.
+type AuthError struct {
+	Err error
+}
+
+// NewAuthError is used to wrap an error authenticating.
+func NewAuthError(e error) error {
+	if e == nil {
+		return nil
+	}
+	return &AuthError{Err: e}
+}
+
+func (a *AuthError) Error() string {
+	return a.Err.Error()
+}
+
+func (a *AuthError) IsRecoverable() bool {
+	return false
+}
+
+// WrapStartError wraps an error starting a task in a new error with the given
+// message. The returned error is a ResourceError or AuthError if the original
+// was; otherwise it is recoverable if the original was.
+func WrapStartError(msg string, err error) error {
+	switch err.(type) {
+	case *ResourceError:
+		return NewResourceError(errors.New(msg))
+	case *AuthError:
+		return NewAuthError(errors.New(msg))
+	}
+	return structs.WrapRecoverable(msg, err)
+}
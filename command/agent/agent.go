@@ -39,6 +39,9 @@ const (
 	// roles used in identifying Consul entries for Nomad agents
 	consulRoleServer = "server"
 	consulRoleClient = "client"
+
+	// minTaskLivenessInterval rate limits the task liveness events
+	minTaskLivenessInterval = 1 * time.Minute
 )
 
 // Agent is a long running daemon that is used to run both
@@ -387,6 +390,71 @@ func (a *Agent) clientConfig() (*clientconfig.Config, error) {
 		}
 		conf.MaxKillTimeout = dur
 	}
+	if a.config.Client.KillBackoffBaseline != "" {
+		dur, err := time.ParseDuration(a.config.Client.KillBackoffBaseline)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing kill backoff baseline: %s", err)
+		}
+		conf.KillBackoffBaseline = dur
+	}
+	if a.config.Client.KillBackoffLimit != "" {
+		dur, err := time.ParseDuration(a.config.Client.KillBackoffLimit)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing kill backoff limit: %s", err)
+		}
+		conf.KillBackoffLimit = dur
+	}
+	if a.config.Client.TaskLivenessInterval != "" {
+		dur, err := time.ParseDuration(a.config.Client.TaskLivenessInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing task liveness interval: %s", err)
+		}
+		if dur < minTaskLivenessInterval {
+			return nil, fmt.Errorf("task liveness interval %v must be at least %v", dur, minTaskLivenessInterval)
+		}
+		conf.TaskLivenessInterval = dur
+	}
+	if a.config.Client.TaskWatchdogTimeout != "" {
+		dur, err := time.ParseDuration(a.config.Client.TaskWatchdogTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing task watchdog timeout: %s", err)
+		}
+		if dur <= 0 {
+			return nil, fmt.Errorf("task watchdog timeout %v must be positive", dur)
+		}
+		conf.TaskWatchdogTimeout = dur
+	}
+	conf.TaskWatchdogKill = a.config.Client.TaskWatchdogKill
+	if t := a.config.Client.TaskMemoryPressureThreshold; t != 0 {
+		if t < 0 || t > 1 {
+			return nil, fmt.Errorf("task memory pressure threshold %v must be between 0 and 1", t)
+		}
+		conf.TaskMemoryPressureThreshold = t
+	}
+	if a.config.Client.TaskStableUptime != "" {
+		dur, err := time.ParseDuration(a.config.Client.TaskStableUptime)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing task stable uptime: %s", err)
+		}
+		if dur <= 0 {
+			return nil, fmt.Errorf("task stable uptime %v must be positive", dur)
+		}
+		conf.TaskStableUptime = dur
+	}
+	if a.config.Client.TaskStateDebounce != "" {
+		dur, err := time.ParseDuration(a.config.Client.TaskStateDebounce)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing task state debounce: %s", err)
+		}
+		if dur < 0 || dur > time.Second {
+			return nil, fmt.Errorf("task state debounce %v must be between 0 and 1s", dur)
+		}
+		conf.TaskStateDebounce = dur
+	}
+	if conf.KillBackoffBaseline > 0 && conf.KillBackoffLimit > 0 && conf.KillBackoffBaseline > conf.KillBackoffLimit {
+		return nil, fmt.Errorf("kill backoff baseline %v must not be greater than the kill backoff limit %v",
+			conf.KillBackoffBaseline, conf.KillBackoffLimit)
+	}
 	conf.ClientMaxPort = uint(a.config.Client.ClientMaxPort)
 	conf.ClientMinPort = uint(a.config.Client.ClientMinPort)
 
@@ -425,6 +493,7 @@ func (a *Agent) clientConfig() (*clientconfig.Config, error) {
 	conf.StatsCollectionInterval = a.config.Telemetry.collectionInterval
 	conf.PublishNodeMetrics = a.config.Telemetry.PublishNodeMetrics
 	conf.PublishAllocationMetrics = a.config.Telemetry.PublishAllocationMetrics
+	conf.AllocMetrics.SetPaused(a.config.Telemetry.PauseAllocationMetrics)
 	conf.DisableTaggedMetrics = a.config.Telemetry.DisableTaggedMetrics
 	conf.BackwardsCompatibleMetrics = a.config.Telemetry.BackwardsCompatibleMetrics
 
@@ -438,6 +507,7 @@ func (a *Agent) clientConfig() (*clientconfig.Config, error) {
 	conf.GCDiskUsageThreshold = a.config.Client.GCDiskUsageThreshold
 	conf.GCInodeUsageThreshold = a.config.Client.GCInodeUsageThreshold
 	conf.GCMaxAllocs = a.config.Client.GCMaxAllocs
+	conf.GCTaskLocalDirs = a.config.Client.GCTaskLocalDirs
 	if a.config.Client.NoHostUUID != nil {
 		conf.NoHostUUID = *a.config.Client.NoHostUUID
 	} else {
@@ -889,6 +959,12 @@ func (a *Agent) ShouldReload(newConfig *Config) (agent, http bool) {
 		agent = true
 	}
 
+	// Allow pausing allocation metrics without reloading connections
+	if a.config.Telemetry != nil && newConfig.Telemetry != nil &&
+		a.config.Telemetry.PauseAllocationMetrics != newConfig.Telemetry.PauseAllocationMetrics {
+		agent = true
+	}
+
 	return agent, http
 }
 
@@ -902,6 +978,15 @@ func (a *Agent) Reload(newConfig *Config) error {
 		return fmt.Errorf("cannot reload agent with nil configuration")
 	}
 
+	if a.config.Telemetry != nil && newConfig.Telemetry != nil {
+		a.config.Telemetry.PauseAllocationMetrics = newConfig.Telemetry.PauseAllocationMetrics
+	}
+
+	// Neither configuration uses TLS so there is nothing left to reload
+	if a.config.TLSConfig.IsEmpty() && newConfig.TLSConfig.IsEmpty() {
+		return nil
+	}
+
 	// This is just a TLS configuration reload, we don't need to refresh
 	// existing network connections
 	if !a.config.TLSConfig.IsEmpty() && !newConfig.TLSConfig.IsEmpty() {
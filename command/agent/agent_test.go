@@ -329,6 +329,161 @@ func TestAget_Client_TelemetryConfiguration(t *testing.T) {
 	assert.Equal(c.BackwardsCompatibleMetrics, telemetry.BackwardsCompatibleMetrics)
 }
 
+// TestAgent_ClientConfig_KillBackoff asserts the kill backoff overrides are
+// parsed and that a baseline greater than the limit is rejected.
+func TestAgent_ClientConfig_KillBackoff(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	conf := DefaultConfig()
+	conf.DevMode = true
+	conf.Client.KillBackoffBaseline = "100ms"
+	conf.Client.KillBackoffLimit = "1s"
+	a := &Agent{config: conf}
+
+	c, err := a.clientConfig()
+	require.NoError(err)
+	require.Equal(100*time.Millisecond, c.KillBackoffBaseline)
+	require.Equal(1*time.Second, c.KillBackoffLimit)
+
+	conf.Client.KillBackoffBaseline = "2s"
+	_, err = a.clientConfig()
+	require.Error(err)
+	require.Contains(err.Error(), "kill backoff baseline")
+
+	conf.Client.KillBackoffBaseline = "bad"
+	_, err = a.clientConfig()
+	require.Error(err)
+}
+
+// TestAgent_ClientConfig_TaskLiveness asserts the task liveness interval is
+// disabled by default and must be at least the minimum when set.
+func TestAgent_ClientConfig_TaskLiveness(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	conf := DefaultConfig()
+	conf.DevMode = true
+	a := &Agent{config: conf}
+
+	c, err := a.clientConfig()
+	require.NoError(err)
+	require.Zero(c.TaskLivenessInterval)
+
+	conf.Client.TaskLivenessInterval = "1h"
+	c, err = a.clientConfig()
+	require.NoError(err)
+	require.Equal(1*time.Hour, c.TaskLivenessInterval)
+
+	conf.Client.TaskLivenessInterval = "1s"
+	_, err = a.clientConfig()
+	require.Error(err)
+	require.Contains(err.Error(), "task liveness interval")
+}
+
+// TestAgent_ClientConfig_TaskWatchdog asserts the task watchdog is disabled
+// by default and its timeout must be positive when set.
+func TestAgent_ClientConfig_TaskWatchdog(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	conf := DefaultConfig()
+	conf.DevMode = true
+	a := &Agent{config: conf}
+
+	c, err := a.clientConfig()
+	require.NoError(err)
+	require.Zero(c.TaskWatchdogTimeout)
+	require.False(c.TaskWatchdogKill)
+
+	conf.Client.TaskWatchdogTimeout = "12h"
+	conf.Client.TaskWatchdogKill = true
+	c, err = a.clientConfig()
+	require.NoError(err)
+	require.Equal(12*time.Hour, c.TaskWatchdogTimeout)
+	require.True(c.TaskWatchdogKill)
+
+	conf.Client.TaskWatchdogTimeout = "-1s"
+	_, err = a.clientConfig()
+	require.Error(err)
+	require.Contains(err.Error(), "task watchdog timeout")
+}
+
+// TestAgent_ClientConfig_TaskMemoryPressure asserts the task memory pressure
+// threshold is disabled by default and must be a fraction when set.
+func TestAgent_ClientConfig_TaskMemoryPressure(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	conf := DefaultConfig()
+	conf.DevMode = true
+	a := &Agent{config: conf}
+
+	c, err := a.clientConfig()
+	require.NoError(err)
+	require.Zero(c.TaskMemoryPressureThreshold)
+
+	conf.Client.TaskMemoryPressureThreshold = 0.9
+	c, err = a.clientConfig()
+	require.NoError(err)
+	require.Equal(0.9, c.TaskMemoryPressureThreshold)
+
+	conf.Client.TaskMemoryPressureThreshold = 1.5
+	_, err = a.clientConfig()
+	require.Error(err)
+	require.Contains(err.Error(), "task memory pressure threshold")
+}
+
+// TestAgent_ClientConfig_TaskStableUptime asserts restart attempts are not
+// reset on uptime by default and the uptime must be positive when set.
+func TestAgent_ClientConfig_TaskStableUptime(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	conf := DefaultConfig()
+	conf.DevMode = true
+	a := &Agent{config: conf}
+
+	c, err := a.clientConfig()
+	require.NoError(err)
+	require.Zero(c.TaskStableUptime)
+
+	conf.Client.TaskStableUptime = "1h"
+	c, err = a.clientConfig()
+	require.NoError(err)
+	require.Equal(time.Hour, c.TaskStableUptime)
+
+	conf.Client.TaskStableUptime = "-1s"
+	_, err = a.clientConfig()
+	require.Error(err)
+	require.Contains(err.Error(), "task stable uptime")
+}
+
+// TestAgent_ClientConfig_TaskStateDebounce asserts task state is not debounced
+// by default and the debounce is bounded when set.
+func TestAgent_ClientConfig_TaskStateDebounce(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	conf := DefaultConfig()
+	conf.DevMode = true
+	a := &Agent{config: conf}
+
+	c, err := a.clientConfig()
+	require.NoError(err)
+	require.Zero(c.TaskStateDebounce)
+
+	conf.Client.TaskStateDebounce = "100ms"
+	c, err = a.clientConfig()
+	require.NoError(err)
+	require.Equal(100*time.Millisecond, c.TaskStateDebounce)
+
+	conf.Client.TaskStateDebounce = "1m"
+	_, err = a.clientConfig()
+	require.Error(err)
+	require.Contains(err.Error(), "task state debounce")
+}
+
 // TestAgent_HTTPCheck asserts Agent.agentHTTPCheck properly alters the HTTP
 // API health check depending on configuration.
 func TestAgent_HTTPCheck(t *testing.T) {
@@ -728,6 +883,41 @@ func TestServer_Reload_TLS_DowngradeFromTLS(t *testing.T) {
 	assert.True(agentConfig.TLSConfig.IsEmpty())
 }
 
+func TestAgent_Reload_PauseAllocationMetrics(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	agent := NewTestAgent(t, t.Name(), nil)
+	defer agent.Shutdown()
+
+	toggle := agent.Agent.Client().GetConfig().AllocMetrics
+	assert.False(toggle.Paused())
+
+	reload := func(paused bool) {
+		newConfig := &Config{
+			TLSConfig: &sconfig.TLSConfig{},
+			Telemetry: &Telemetry{PauseAllocationMetrics: paused},
+		}
+
+		shouldReloadAgent, shouldReloadHTTP := agent.ShouldReload(newConfig)
+		assert.True(shouldReloadAgent)
+		assert.False(shouldReloadHTTP)
+		assert.Nil(agent.Reload(newConfig))
+
+		clientConfig, err := agent.clientConfig()
+		assert.Nil(err)
+		assert.Nil(agent.Agent.Client().Reload(clientConfig))
+	}
+
+	reload(true)
+	assert.True(agent.GetConfig().Telemetry.PauseAllocationMetrics)
+	assert.True(toggle.Paused())
+
+	reload(false)
+	assert.False(agent.GetConfig().Telemetry.PauseAllocationMetrics)
+	assert.False(toggle.Paused())
+}
+
 func TestServer_ShouldReload_ReturnFalseForNoChanges(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -56,6 +56,14 @@ client {
 	client_min_port = 1000
 	client_max_port = 2000
 	max_kill_timeout = "10s"
+	kill_backoff_baseline = "1s"
+	kill_backoff_limit = "30s"
+	task_liveness_interval = "1h"
+	task_watchdog_timeout = "24h"
+	task_watchdog_kill = true
+	task_memory_pressure_threshold = 0.9
+	task_stable_uptime = "1h"
+	task_state_debounce = "100ms"
 	stats {
 		data_points = 35
 		collection_interval = "5s"
@@ -65,6 +73,7 @@ client {
 	gc_disk_usage_threshold = 82
 	gc_inode_usage_threshold = 91
 	gc_max_allocs = 50
+	gc_task_local_dirs = true
 	no_host_uuid = false
 }
 server {
@@ -112,6 +121,7 @@ telemetry {
 	collection_interval = "3s"
 	publish_allocation_metrics = true
 	publish_node_metrics = true
+	pause_allocation_metrics = true
 	disable_tagged_metrics = true
 	backwards_compatible_metrics = true
 }
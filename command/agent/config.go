@@ -181,6 +181,34 @@ type ClientConfig struct {
 	// MaxKillTimeout allows capping the user-specifiable KillTimeout.
 	MaxKillTimeout string `mapstructure:"max_kill_timeout"`
 
+	// KillBackoffBaseline and KillBackoffLimit override the exponential
+	// backoff between failed attempts to kill a task.
+	KillBackoffBaseline string `mapstructure:"kill_backoff_baseline"`
+	KillBackoffLimit    string `mapstructure:"kill_backoff_limit"`
+
+	// TaskLivenessInterval is the interval at which running tasks emit an
+	// event showing they are still running. Disabled if unset.
+	TaskLivenessInterval string `mapstructure:"task_liveness_interval"`
+
+	// TaskWatchdogTimeout is how long a task of a batch job may run without
+	// exiting before a warning event is emitted. Disabled if unset.
+	TaskWatchdogTimeout string `mapstructure:"task_watchdog_timeout"`
+
+	// TaskWatchdogKill kills tasks that run past the TaskWatchdogTimeout.
+	TaskWatchdogKill bool `mapstructure:"task_watchdog_kill"`
+
+	// TaskMemoryPressureThreshold is the fraction of a task's memory limit
+	// above which a warning event is emitted. Disabled if unset.
+	TaskMemoryPressureThreshold float64 `mapstructure:"task_memory_pressure_threshold"`
+
+	// TaskStableUptime is how long a task must run before exiting for its
+	// restart attempts to be reset. Disabled if unset.
+	TaskStableUptime string `mapstructure:"task_stable_uptime"`
+
+	// TaskStateDebounce delays writing a task runner's state after it changes
+	// so a burst of changes is coalesced into one write. Disabled if unset.
+	TaskStateDebounce string `mapstructure:"task_state_debounce"`
+
 	// ClientMaxPort is the upper range of the ports that the client uses for
 	// communicating with plugin subsystems
 	ClientMaxPort int `mapstructure:"client_max_port"`
@@ -214,6 +242,10 @@ type ClientConfig struct {
 	// before garbage collection is triggered.
 	GCMaxAllocs int `mapstructure:"gc_max_allocs"`
 
+	// GCTaskLocalDirs enables removing a task's local and secrets
+	// directories once the task is dead and will not be restarted.
+	GCTaskLocalDirs bool `mapstructure:"gc_task_local_dirs"`
+
 	// NoHostUUID disables using the host's UUID and will force generation of a
 	// random UUID.
 	NoHostUUID *bool `mapstructure:"no_host_uuid"`
@@ -426,6 +458,10 @@ type Telemetry struct {
 	PublishAllocationMetrics bool          `mapstructure:"publish_allocation_metrics"`
 	PublishNodeMetrics       bool          `mapstructure:"publish_node_metrics"`
 
+	// PauseAllocationMetrics pauses publishing allocation metrics. Unlike
+	// PublishAllocationMetrics it can be changed by reloading the agent.
+	PauseAllocationMetrics bool `mapstructure:"pause_allocation_metrics"`
+
 	// DisableTaggedMetrics disables a new version of generating metrics which
 	// uses tags
 	DisableTaggedMetrics bool `mapstructure:"disable_tagged_metrics"`
@@ -1171,6 +1207,30 @@ func (a *ClientConfig) Merge(b *ClientConfig) *ClientConfig {
 	if b.MaxKillTimeout != "" {
 		result.MaxKillTimeout = b.MaxKillTimeout
 	}
+	if b.KillBackoffBaseline != "" {
+		result.KillBackoffBaseline = b.KillBackoffBaseline
+	}
+	if b.KillBackoffLimit != "" {
+		result.KillBackoffLimit = b.KillBackoffLimit
+	}
+	if b.TaskLivenessInterval != "" {
+		result.TaskLivenessInterval = b.TaskLivenessInterval
+	}
+	if b.TaskWatchdogTimeout != "" {
+		result.TaskWatchdogTimeout = b.TaskWatchdogTimeout
+	}
+	if b.TaskWatchdogKill {
+		result.TaskWatchdogKill = true
+	}
+	if b.TaskMemoryPressureThreshold != 0 {
+		result.TaskMemoryPressureThreshold = b.TaskMemoryPressureThreshold
+	}
+	if b.TaskStableUptime != "" {
+		result.TaskStableUptime = b.TaskStableUptime
+	}
+	if b.TaskStateDebounce != "" {
+		result.TaskStateDebounce = b.TaskStateDebounce
+	}
 	if b.ClientMaxPort != 0 {
 		result.ClientMaxPort = b.ClientMaxPort
 	}
@@ -1198,6 +1258,9 @@ func (a *ClientConfig) Merge(b *ClientConfig) *ClientConfig {
 	if b.GCMaxAllocs != 0 {
 		result.GCMaxAllocs = b.GCMaxAllocs
 	}
+	if b.GCTaskLocalDirs {
+		result.GCTaskLocalDirs = true
+	}
 	// NoHostUUID defaults to true, merge if false
 	if b.NoHostUUID != nil {
 		result.NoHostUUID = b.NoHostUUID
@@ -1275,6 +1338,9 @@ func (a *Telemetry) Merge(b *Telemetry) *Telemetry {
 	if b.PublishAllocationMetrics {
 		result.PublishAllocationMetrics = true
 	}
+	if b.PauseAllocationMetrics {
+		result.PauseAllocationMetrics = true
+	}
 	if b.CirconusAPIToken != "" {
 		result.CirconusAPIToken = b.CirconusAPIToken
 	}
@@ -360,6 +360,14 @@ func parseClient(result **ClientConfig, list *ast.ObjectList) error {
 		"memory_total_mb",
 		"cpu_total_compute",
 		"max_kill_timeout",
+		"kill_backoff_baseline",
+		"kill_backoff_limit",
+		"task_liveness_interval",
+		"task_watchdog_timeout",
+		"task_watchdog_kill",
+		"task_memory_pressure_threshold",
+		"task_stable_uptime",
+		"task_state_debounce",
 		"client_max_port",
 		"client_min_port",
 		"reserved",
@@ -369,6 +377,7 @@ func parseClient(result **ClientConfig, list *ast.ObjectList) error {
 		"gc_inode_usage_threshold",
 		"gc_parallel_destroys",
 		"gc_max_allocs",
+		"gc_task_local_dirs",
 		"no_host_uuid",
 		"server_join",
 	}
@@ -706,6 +715,7 @@ func parseTelemetry(result **Telemetry, list *ast.ObjectList) error {
 		"collection_interval",
 		"publish_allocation_metrics",
 		"publish_node_metrics",
+		"pause_allocation_metrics",
 		"datadog_address",
 		"datadog_tags",
 		"prometheus_metrics",
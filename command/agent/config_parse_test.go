@@ -64,13 +64,21 @@ func TestConfig_Parse(t *testing.T) {
 						"/opt/myapp/etc": "/etc",
 						"/opt/myapp/bin": "/bin",
 					},
-					NetworkInterface: "eth0",
-					NetworkSpeed:     100,
-					CpuCompute:       4444,
-					MemoryMB:         0,
-					MaxKillTimeout:   "10s",
-					ClientMinPort:    1000,
-					ClientMaxPort:    2000,
+					NetworkInterface:            "eth0",
+					NetworkSpeed:                100,
+					CpuCompute:                  4444,
+					MemoryMB:                    0,
+					MaxKillTimeout:              "10s",
+					KillBackoffBaseline:         "1s",
+					KillBackoffLimit:            "30s",
+					TaskLivenessInterval:        "1h",
+					TaskWatchdogTimeout:         "24h",
+					TaskWatchdogKill:            true,
+					TaskMemoryPressureThreshold: 0.9,
+					TaskStableUptime:            "1h",
+					TaskStateDebounce:           "100ms",
+					ClientMinPort:               1000,
+					ClientMaxPort:               2000,
 					Reserved: &Resources{
 						CPU:                 10,
 						MemoryMB:            10,
@@ -84,6 +92,7 @@ func TestConfig_Parse(t *testing.T) {
 					GCDiskUsageThreshold:  82,
 					GCInodeUsageThreshold: 91,
 					GCMaxAllocs:           50,
+					GCTaskLocalDirs:       true,
 					NoHostUUID:            helper.BoolToPtr(false),
 				},
 				Server: &ServerConfig{
@@ -133,6 +142,7 @@ func TestConfig_Parse(t *testing.T) {
 					collectionInterval:         3 * time.Second,
 					PublishAllocationMetrics:   true,
 					PublishNodeMetrics:         true,
+					PauseAllocationMetrics:     true,
 					DisableTaggedMetrics:       true,
 					BackwardsCompatibleMetrics: true,
 				},
@@ -264,11 +274,11 @@ func TestConfig_Parse(t *testing.T) {
 				SyslogFacility:            "",
 				DisableUpdateCheck:        nil,
 				DisableAnonymousSignature: false,
-				Consul:                 nil,
-				Vault:                  nil,
-				TLSConfig:              nil,
-				HTTPAPIResponseHeaders: nil,
-				Sentinel:               nil,
+				Consul:                    nil,
+				Vault:                     nil,
+				TLSConfig:                 nil,
+				HTTPAPIResponseHeaders:    nil,
+				Sentinel:                  nil,
 			},
 			false,
 		},
@@ -85,11 +85,18 @@ func TestConfig_Merge(t *testing.T) {
 			Options: map[string]string{
 				"foo": "bar",
 			},
-			NetworkSpeed:   100,
-			CpuCompute:     100,
-			MemoryMB:       100,
-			MaxKillTimeout: "20s",
-			ClientMaxPort:  19996,
+			NetworkSpeed:                100,
+			CpuCompute:                  100,
+			MemoryMB:                    100,
+			MaxKillTimeout:              "20s",
+			KillBackoffBaseline:         "1s",
+			KillBackoffLimit:            "20s",
+			TaskLivenessInterval:        "1h",
+			TaskWatchdogTimeout:         "12h",
+			TaskMemoryPressureThreshold: 0.8,
+			TaskStableUptime:            "30m",
+			TaskStateDebounce:           "50ms",
+			ClientMaxPort:               19996,
 			Reserved: &Resources{
 				CPU:                 10,
 				MemoryMB:            10,
@@ -200,6 +207,7 @@ func TestConfig_Merge(t *testing.T) {
 			DisableHostname:                    true,
 			PublishNodeMetrics:                 true,
 			PublishAllocationMetrics:           true,
+			PauseAllocationMetrics:             true,
 			DisableTaggedMetrics:               true,
 			BackwardsCompatibleMetrics:         true,
 			CirconusAPIToken:                   "1",
@@ -229,13 +237,21 @@ func TestConfig_Merge(t *testing.T) {
 				"foo": "bar",
 				"baz": "zip",
 			},
-			ChrootEnv:      map[string]string{},
-			ClientMaxPort:  20000,
-			ClientMinPort:  22000,
-			NetworkSpeed:   105,
-			CpuCompute:     105,
-			MemoryMB:       105,
-			MaxKillTimeout: "50s",
+			ChrootEnv:                   map[string]string{},
+			ClientMaxPort:               20000,
+			ClientMinPort:               22000,
+			NetworkSpeed:                105,
+			CpuCompute:                  105,
+			MemoryMB:                    105,
+			MaxKillTimeout:              "50s",
+			KillBackoffBaseline:         "5s",
+			KillBackoffLimit:            "50s",
+			TaskLivenessInterval:        "2h",
+			TaskWatchdogTimeout:         "24h",
+			TaskWatchdogKill:            true,
+			TaskMemoryPressureThreshold: 0.9,
+			TaskStableUptime:            "1h",
+			TaskStateDebounce:           "100ms",
 			Reserved: &Resources{
 				CPU:                 15,
 				MemoryMB:            15,
@@ -676,10 +676,12 @@ func ApiTgToStructsTG(taskGroup *api.TaskGroup, tg *structs.TaskGroup) {
 	}
 
 	tg.RestartPolicy = &structs.RestartPolicy{
-		Attempts: *taskGroup.RestartPolicy.Attempts,
-		Interval: *taskGroup.RestartPolicy.Interval,
-		Delay:    *taskGroup.RestartPolicy.Delay,
-		Mode:     *taskGroup.RestartPolicy.Mode,
+		Attempts:     *taskGroup.RestartPolicy.Attempts,
+		Interval:     *taskGroup.RestartPolicy.Interval,
+		Delay:        *taskGroup.RestartPolicy.Delay,
+		InitialDelay: *taskGroup.RestartPolicy.InitialDelay,
+		Jitter:       *taskGroup.RestartPolicy.Jitter,
+		Mode:         *taskGroup.RestartPolicy.Mode,
 	}
 
 	if taskGroup.ReschedulePolicy != nil {
@@ -423,6 +423,8 @@ func parseRestartPolicy(final **api.RestartPolicy, list *ast.ObjectList) error {
 		"attempts",
 		"interval",
 		"delay",
+		"initial_delay",
+		"jitter",
 		"mode",
 	}
 	if err := helper.CheckHCLKeys(obj.Val, valid); err != nil {
@@ -90,10 +90,12 @@ func TestParse(t *testing.T) {
 							"elb_checks":   "3",
 						},
 						RestartPolicy: &api.RestartPolicy{
-							Interval: helper.TimeToPtr(10 * time.Minute),
-							Attempts: helper.IntToPtr(5),
-							Delay:    helper.TimeToPtr(15 * time.Second),
-							Mode:     helper.StringToPtr("delay"),
+							Interval:     helper.TimeToPtr(10 * time.Minute),
+							Attempts:     helper.IntToPtr(5),
+							Delay:        helper.TimeToPtr(15 * time.Second),
+							InitialDelay: helper.TimeToPtr(5 * time.Second),
+							Jitter:       helper.TimeToPtr(30 * time.Second),
+							Mode:         helper.StringToPtr("delay"),
 						},
 						ReschedulePolicy: &api.ReschedulePolicy{
 							Interval: helper.TimeToPtr(12 * time.Hour),
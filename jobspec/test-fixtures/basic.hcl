@@ -43,10 +43,12 @@ job "binstore-storagelocker" {
     count = 5
 
     restart {
-      attempts = 5
-      interval = "10m"
-      delay    = "15s"
-      mode     = "delay"
+      attempts      = 5
+      interval      = "10m"
+      delay         = "15s"
+      initial_delay = "5s"
+      jitter        = "30s"
+      mode          = "delay"
     }
 
     reschedule {
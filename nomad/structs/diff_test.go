@@ -1333,12 +1333,24 @@ func TestTaskGroupDiff(t *testing.T) {
 								Old:  "",
 								New:  "1000000000",
 							},
+							{
+								Type: DiffTypeAdded,
+								Name: "InitialDelay",
+								Old:  "",
+								New:  "0",
+							},
 							{
 								Type: DiffTypeAdded,
 								Name: "Interval",
 								Old:  "",
 								New:  "1000000000",
 							},
+							{
+								Type: DiffTypeAdded,
+								Name: "Jitter",
+								Old:  "",
+								New:  "0",
+							},
 							{
 								Type: DiffTypeAdded,
 								Name: "Mode",
@@ -1380,12 +1392,24 @@ func TestTaskGroupDiff(t *testing.T) {
 								Old:  "1000000000",
 								New:  "",
 							},
+							{
+								Type: DiffTypeDeleted,
+								Name: "InitialDelay",
+								Old:  "0",
+								New:  "",
+							},
 							{
 								Type: DiffTypeDeleted,
 								Name: "Interval",
 								Old:  "1000000000",
 								New:  "",
 							},
+							{
+								Type: DiffTypeDeleted,
+								Name: "Jitter",
+								Old:  "0",
+								New:  "",
+							},
 							{
 								Type: DiffTypeDeleted,
 								Name: "Mode",
@@ -1489,12 +1513,24 @@ func TestTaskGroupDiff(t *testing.T) {
 								Old:  "1000000000",
 								New:  "1000000000",
 							},
+							{
+								Type: DiffTypeNone,
+								Name: "InitialDelay",
+								Old:  "0",
+								New:  "0",
+							},
 							{
 								Type: DiffTypeEdited,
 								Name: "Interval",
 								Old:  "1000000000",
 								New:  "2000000000",
 							},
+							{
+								Type: DiffTypeNone,
+								Name: "Jitter",
+								Old:  "0",
+								New:  "0",
+							},
 							{
 								Type: DiffTypeNone,
 								Name: "Mode",
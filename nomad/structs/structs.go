@@ -2960,6 +2960,15 @@ type RestartPolicy struct {
 	// Delay is the time between a failure and a restart.
 	Delay time.Duration
 
+	// InitialDelay, if set, is used in place of Delay for the first restart
+	// in an interval so transient failures are retried quickly.
+	InitialDelay time.Duration
+
+	// Jitter is the maximum random duration added to the delay when the
+	// attempts are exhausted in "delay" mode. It spreads out restarts of
+	// tasks that failed at the same time.
+	Jitter time.Duration
+
 	// Mode controls what happens when the task restarts more than attempt times
 	// in an interval.
 	Mode string
@@ -2994,6 +3003,12 @@ func (r *RestartPolicy) Validate() error {
 		multierror.Append(&mErr,
 			fmt.Errorf("Nomad can't restart the TaskGroup %v times in an interval of %v with a delay of %v", r.Attempts, r.Interval, r.Delay))
 	}
+	if r.InitialDelay < 0 {
+		multierror.Append(&mErr, fmt.Errorf("Initial delay can not be negative (got %v)", r.InitialDelay))
+	}
+	if r.Jitter < 0 {
+		multierror.Append(&mErr, fmt.Errorf("Jitter can not be negative (got %v)", r.Jitter))
+	}
 	return mErr.ErrorOrNil()
 }
 
@@ -4660,6 +4675,13 @@ const (
 
 	// TaskLeaderDead indicates that the leader task within the has finished.
 	TaskLeaderDead = "Leader Task Dead"
+
+	// TaskPaused indicates that the task has been stopped and will not be
+	// started again until it is resumed.
+	TaskPaused = "Paused"
+
+	// TaskResumed indicates that a paused task is being started again.
+	TaskResumed = "Resumed"
 )
 
 // TaskEvent is an event that effects the state of a task and contains meta-data
@@ -4676,6 +4698,14 @@ type TaskEvent struct {
 	// Details is a map with annotated info about the event
 	Details map[string]string
 
+	// Severity is the severity of the event, one of the TaskEventSeverity
+	// constants, and can be used to filter events.
+	Severity string
+
+	// Sticky marks important events that are kept when older events are
+	// evicted to make room for new ones.
+	Sticky bool
+
 	// DEPRECATION NOTICE: The following fields are deprecated and will be removed
 	// in a future release. Field values are available in the Details map.
 
@@ -4869,6 +4899,10 @@ func (event *TaskEvent) PopulateEventDisplayMessage() {
 		desc = event.DriverMessage
 	case TaskLeaderDead:
 		desc = "Leader Task in Group dead"
+	case TaskPaused:
+		desc = "Task paused"
+	case TaskResumed:
+		desc = "Task resumed"
 	default:
 		desc = event.Message
 	}
@@ -4896,14 +4930,53 @@ func (te *TaskEvent) Copy() *TaskEvent {
 	return copy
 }
 
+const (
+	// TaskEventSeverityInfo is the severity of events reporting the normal
+	// lifecycle of a task.
+	TaskEventSeverityInfo = "info"
+
+	// TaskEventSeverityWarn is the severity of events reporting a problem the
+	// task may recover from, such as a restart.
+	TaskEventSeverityWarn = "warn"
+
+	// TaskEventSeverityError is the severity of events reporting a failure.
+	TaskEventSeverityError = "error"
+)
+
+// TaskEventSeverity returns the default severity of events of the given type.
+func TaskEventSeverity(eventType string) string {
+	switch eventType {
+	case TaskSetupFailure, TaskDriverFailure, TaskFailedValidation,
+		TaskArtifactDownloadFailed, TaskNotRestarting, TaskDiskExceeded:
+		return TaskEventSeverityError
+	case TaskRestarting, TaskSiblingFailed, TaskLeaderDead:
+		return TaskEventSeverityWarn
+	default:
+		return TaskEventSeverityInfo
+	}
+}
+
 func NewTaskEvent(event string) *TaskEvent {
 	return &TaskEvent{
-		Type:    event,
-		Time:    time.Now().UnixNano(),
-		Details: make(map[string]string),
+		Type:     event,
+		Time:     time.Now().UnixNano(),
+		Details:  make(map[string]string),
+		Severity: TaskEventSeverity(event),
 	}
 }
 
+// SetSeverity overrides the default severity of the event.
+func (e *TaskEvent) SetSeverity(severity string) *TaskEvent {
+	e.Severity = severity
+	return e
+}
+
+// SetSticky marks the event to be kept when older events are evicted.
+func (e *TaskEvent) SetSticky() *TaskEvent {
+	e.Sticky = true
+	return e
+}
+
 // SetSetupError is used to store an error that occurred while setting up the
 // task
 func (e *TaskEvent) SetSetupError(err error) *TaskEvent {
@@ -4917,6 +4990,7 @@ func (e *TaskEvent) SetSetupError(err error) *TaskEvent {
 func (e *TaskEvent) SetFailsTask() *TaskEvent {
 	e.FailsTask = true
 	e.Details["fails_task"] = "true"
+	e.Severity = TaskEventSeverityError
 	return e
 }
 
@@ -4952,6 +5026,7 @@ func (e *TaskEvent) SetKillError(err error) *TaskEvent {
 	if err != nil {
 		e.KillError = err.Error()
 		e.Details["kill_error"] = err.Error()
+		e.Severity = TaskEventSeverityError
 	}
 	return e
 }
@@ -2167,6 +2167,28 @@ func TestRestartPolicy_Validate(t *testing.T) {
 		t.Fatalf("expect restart interval error, got: %v", err)
 	}
 
+	// Fails when the initial delay is negative
+	p = &RestartPolicy{
+		Mode:         RestartPolicyModeFail,
+		Attempts:     1,
+		Interval:     5 * time.Second,
+		InitialDelay: -1 * time.Second,
+	}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "Initial delay") {
+		t.Fatalf("expect initial delay error, got: %v", err)
+	}
+
+	// Fails when the jitter is negative
+	p = &RestartPolicy{
+		Mode:     RestartPolicyModeDelay,
+		Attempts: 1,
+		Interval: 5 * time.Second,
+		Jitter:   -1 * time.Second,
+	}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "Jitter") {
+		t.Fatalf("expect jitter error, got: %v", err)
+	}
+
 	// Fails when interval is to small
 	p = &RestartPolicy{
 		Mode:     RestartPolicyModeDelay,
@@ -3566,6 +3588,37 @@ func TestTaskEventPopulate(t *testing.T) {
 	}
 }
 
+func TestTaskEvent_Severity(t *testing.T) {
+	testcases := []struct {
+		event    *TaskEvent
+		expected string
+	}{
+		{NewTaskEvent(TaskReceived), TaskEventSeverityInfo},
+		{NewTaskEvent(TaskStarted), TaskEventSeverityInfo},
+		{NewTaskEvent(TaskTerminated), TaskEventSeverityInfo},
+		{NewTaskEvent(TaskKilled), TaskEventSeverityInfo},
+		{NewTaskEvent(TaskRestarting), TaskEventSeverityWarn},
+		{NewTaskEvent(TaskSiblingFailed), TaskEventSeverityWarn},
+		{NewTaskEvent(TaskSetupFailure), TaskEventSeverityError},
+		{NewTaskEvent(TaskDriverFailure), TaskEventSeverityError},
+		{NewTaskEvent(TaskFailedValidation), TaskEventSeverityError},
+		{NewTaskEvent(TaskArtifactDownloadFailed), TaskEventSeverityError},
+		{NewTaskEvent(TaskNotRestarting), TaskEventSeverityError},
+		{NewTaskEvent(TaskDiskExceeded), TaskEventSeverityError},
+		{NewTaskEvent(TaskKilled).SetKillError(fmt.Errorf("kill failed")), TaskEventSeverityError},
+		{NewTaskEvent(TaskKilled).SetKillError(nil), TaskEventSeverityInfo},
+		{NewTaskEvent(TaskSetup).SetFailsTask(), TaskEventSeverityError},
+		{NewTaskEvent(TaskDriverMessage).SetSeverity(TaskEventSeverityWarn), TaskEventSeverityWarn},
+	}
+
+	for _, tc := range testcases {
+		if tc.event.Severity != tc.expected {
+			t.Fatalf("Expected %q event to have severity %q but found %q",
+				tc.event.Type, tc.expected, tc.event.Severity)
+		}
+	}
+}
+
 func TestNetworkResourcesEquals(t *testing.T) {
 	require := require.New(t)
 	var networkResourcesTest = []struct {